@@ -1,50 +1,411 @@
 package goraph
 
 import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
 	"sync"
 )
 
+/*
+NeuralNetwork trains and runs a computing graph created by a build function.
+A network created by NewNeuralNetwork builds one graph per sample and runs the
+samples of a batch concurrently. A network created by NewBatchNeuralNetwork
+passes the batch size to the build function instead, and a whole mini-batch
+flows through a single graph with one sample per row of the input and target
+matrices.
+
+The network counts the finished epochs and optimization steps, and owns the
+random source used to shuffle the training samples, so that all of them can
+be saved with SaveCheckpoint and restored with Resume. Before every training
+step, the network also gives each RandomNode of the graphs, such as a
+DropoutNode, a generator derived from that source and the index of the
+sample, and it adds the gradients of concurrently trained samples in the
+order of the samples, so a training run is reproducible for a given seed. A
+network that is not seeded by a Trainer is seeded from the library random
+source.
+
+Training puts the graphs in training mode, while Evaluate and Predict put them
+in inference mode, so that nodes such as DropoutNode behave as appropriate.
+
+If AccumulationSteps is greater than 1, the gradients of that many batches are
+averaged before each optimization step, which trains with a large effective
+batch size while only one batch at a time is held in memory. If ClipNorm or
+ClipValue is positive, the averaged gradients are clipped with ClipGradNorm or
+ClipGradValue before each step. Both need an optimizer that implements
+ParameterOptimizer. Constraints are applied after every step.
+*/
 type NeuralNetwork struct {
-	buildFunc func() (input, target *VariableNode, output, loss Node)
-	optimizer Optimizer
+	buildFunc         func() (input, target *VariableNode, output, loss Node)
+	batchBuildFunc    func(batchSize int) (input, target *VariableNode, output, loss Node)
+	batchGraphs       map[int]*networkGraph
+	sampleGraphs      []*networkGraph
+	optimizer         Optimizer
+	epoch, step       int
+	source            *rand.PCG
+	rand              *rand.Rand
+	gradients         []*Matrix
+	gradientRows      []map[int]bool
+	pendingBatches    int
+	pendingScale      int
+	EvalBatchSize     int
+	AccumulationSteps int
+	ClipNorm          float64
+	ClipValue         float64
+	Constraints       []Constraint
+}
+
+type networkGraph struct {
+	input, target *VariableNode
+	output, loss  Node
+	randomNodes   []RandomNode
+	modeNodes     []ModeNode
+}
+
+func newNetworkGraph(input, target *VariableNode, output, loss Node) *networkGraph {
+	g := &networkGraph{input: input, target: target, output: output, loss: loss}
+	seen := make(map[Node]bool)
+	for _, root := range []Node{loss, output} {
+		for _, node := range topologicalOrder(root) {
+			if seen[node] {
+				continue
+			}
+			seen[node] = true
+			if r, ok := node.(RandomNode); ok {
+				g.randomNodes = append(g.randomNodes, r)
+			}
+			if m, ok := node.(ModeNode); ok {
+				g.modeNodes = append(g.modeNodes, m)
+			}
+		}
+	}
+	return g
+}
+
+func (g *networkGraph) setTraining(training bool) {
+	for _, node := range g.modeNodes {
+		node.SetTraining(training)
+	}
+}
+
+func (g *networkGraph) setRand(r *rand.Rand) {
+	for _, node := range g.randomNodes {
+		node.SetRand(r)
+	}
+}
+
+const defaultEvalBatchSize = 64
+
+/*
+Epoch returns the number of finished training epochs.
+*/
+func (nn *NeuralNetwork) Epoch() int {
+	return nn.epoch
+}
+
+/*
+Steps returns the number of optimization steps taken so far.
+*/
+func (nn *NeuralNetwork) Steps() int {
+	return nn.step
+}
+
+/*
+seed sets the random source of the network, unless it already has one, for
+example restored by Resume.
+*/
+func (nn *NeuralNetwork) seed(seed uint64) {
+	if nn.source == nil {
+		nn.source = rand.NewPCG(seed, seed)
+		nn.rand = rand.New(nn.source)
+	}
+}
+
+/*
+streamSeed returns the seed of the random streams for a training step on
+graphs like the given one. Nothing is drawn for graphs without random nodes.
+*/
+func (nn *NeuralNetwork) streamSeed(graph *networkGraph) (seed uint64, ok bool) {
+	if len(graph.randomNodes) == 0 {
+		return 0, false
+	}
+	nn.seed(libraryRand.Uint64())
+	return nn.rand.Uint64(), true
 }
 
+/*
+Train runs one epoch over the data in batches of batchSize, in order, and
+returns the mean loss.
+*/
 func (nn *NeuralNetwork) Train(inputData, targetData [][]float64, batchSize int) (lossValue float64) {
-	inputs := make([]*VariableNode, batchSize)
-	targets := make([]*VariableNode, batchSize)
-	losses := make([]Node, batchSize)
-	for i := 0; i < batchSize; i++ {
-		inputs[i], targets[i], _, losses[i] = nn.buildFunc()
-	}
-	for i := 0; i*batchSize < len(inputData); i++ {
-		realBatchSize := min(len(inputData)-i*batchSize, batchSize)
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-		for j := 0; j < realBatchSize; j++ {
-			wg.Add(1)
-			go func(batch, idx, batchSize int) {
-				inputs[idx].Value = NewMatrix(inputs[idx].Value.Rows, inputs[idx].Value.Cols, inputData[batch*batchSize+idx])
-				targets[idx].Value = NewMatrix(targets[idx].Value.Rows, targets[idx].Value.Cols, targetData[batch*batchSize+idx])
-				lossValueItem := losses[idx].Forward().Data[0]
-				mu.Lock()
-				lossValue += lossValueItem
-				mu.Unlock()
-				losses[idx].Backward(nil)
-				wg.Done()
-			}(i, j, batchSize)
+	for start := 0; start < len(inputData); start += batchSize {
+		end := min(len(inputData), start+batchSize)
+		lossValue += nn.TrainBatch(inputData[start:end], targetData[start:end]) * float64(end-start)
+	}
+	nn.flush()
+	lossValue /= float64(len(inputData))
+	nn.epoch++
+	return
+}
+
+/*
+TrainBatch runs one optimization step on a single mini-batch, or accumulates
+its gradients when AccumulationSteps is greater than 1, and returns the mean
+loss of its samples.
+*/
+func (nn *NeuralNetwork) TrainBatch(inputData, targetData [][]float64) (lossValue float64) {
+	if nn.batchBuildFunc != nil {
+		g := nn.graphFor(len(inputData))
+		g.feed(inputData, targetData)
+		g.setTraining(true)
+		if seed, ok := nn.streamSeed(g); ok {
+			g.setRand(deriveRand(seed, 0))
 		}
-		wg.Wait()
-		nn.optimizer.Step(realBatchSize)
-		for j := 0; j < realBatchSize; j++ {
-			losses[j].Reset()
+		lossValue = g.loss.Forward().Data[0]
+		g.loss.Backward(nil)
+		// The losses are averaged over the batch, so the gradients need no
+		// further scaling.
+		nn.update([]*networkGraph{g}, 1)
+		return
+	}
+	graphs := nn.sampleGraphsFor(len(inputData))
+	seed, random := nn.streamSeed(graphs[0])
+	losses := make([]float64, len(inputData))
+	grads := make([][]leafGradient, len(inputData))
+	var wg sync.WaitGroup
+	for idx := range inputData {
+		wg.Add(1)
+		go func(g *networkGraph, idx int) {
+			g.input.Value = NewMatrix(g.input.Value.Rows, g.input.Value.Cols, inputData[idx])
+			g.target.Value = NewMatrix(g.target.Value.Rows, g.target.Value.Cols, targetData[idx])
+			g.setTraining(true)
+			if random {
+				g.setRand(deriveRand(seed, uint64(idx)))
+			}
+			losses[idx] = g.loss.Forward().Data[0]
+			if _, ok := g.loss.(OperatorNode); ok {
+				grads[idx] = leafGradients(g.loss, nil)
+			} else {
+				g.loss.Backward(nil)
+			}
+			wg.Done()
+		}(graphs[idx], idx)
+	}
+	wg.Wait()
+	for idx := range inputData {
+		lossValue += losses[idx]
+		for _, lg := range grads[idx] {
+			lg.apply()
 		}
 	}
+	nn.update(graphs, len(inputData))
 	lossValue /= float64(len(inputData))
 	return
 }
 
+/*
+update applies the gradients computed in graphs, which are the sums of
+gradients of the samples divided by scale, and resets the graphs. With
+accumulation or clipping, the gradients are first added to those of the
+pending batches, and the step is taken once AccumulationSteps batches are
+pending. Like the optimizers, the accumulation and clipping keep to the rows
+of the gradients delivered with BackwardRows, so an Embedding table is still
+updated lazily.
+*/
+func (nn *NeuralNetwork) update(graphs []*networkGraph, scale int) {
+	if nn.AccumulationSteps <= 1 && nn.ClipNorm <= 0 && nn.ClipValue <= 0 {
+		nn.optimizer.Step(scale)
+		nn.applyConstraints()
+		nn.step++
+		for _, g := range graphs {
+			g.loss.Reset()
+		}
+		return
+	}
+	params := nn.parameters()
+	if nn.gradients == nil {
+		nn.gradients = make([]*Matrix, len(params))
+		nn.gradientRows = make([]map[int]bool, len(params))
+		for i, p := range params {
+			nn.gradients[i] = NewConstMatrix(p.Value.Rows, p.Value.Cols, 0)
+			nn.gradientRows[i] = map[int]bool{}
+		}
+	}
+	// Resetting the graphs clears the gradients of the parameters, so they
+	// are kept aside until the step is taken.
+	for i, p := range params {
+		nn.accumulate(i, p)
+	}
+	for _, g := range graphs {
+		g.loss.Reset()
+	}
+	nn.pendingBatches++
+	nn.pendingScale += scale
+	if nn.pendingBatches >= nn.AccumulationSteps {
+		nn.flush()
+	}
+}
+
+/*
+accumulate adds the gradient of p to the pending gradient of parameter i. As
+long as p only receives gradients in some rows, only those rows are added and
+remembered in gradientRows; a nil entry means that any row may be pending.
+*/
+func (nn *NeuralNetwork) accumulate(i int, p *VariableNode) {
+	acc := nn.gradients[i]
+	if rows, ok := p.GradientRows(); ok && nn.gradientRows[i] != nil {
+		for _, r := range rows {
+			for j := r * acc.Cols; j < (r+1)*acc.Cols; j++ {
+				acc.Data[j] += p.Gradient.Data[j]
+			}
+			nn.gradientRows[i][r] = true
+		}
+		return
+	}
+	nn.gradients[i] = acc.Add(p.Gradient)
+	nn.gradientRows[i] = nil
+}
+
+/*
+flush takes an optimization step with the gradients of the pending batches, if
+there are any.
+*/
+func (nn *NeuralNetwork) flush() {
+	if nn.pendingBatches == 0 {
+		return
+	}
+	params := nn.parameters()
+	for i, p := range params {
+		scale := 1 / float64(nn.pendingScale)
+		rows := nn.gradientRows[i]
+		if rows == nil {
+			p.Gradient = nn.gradients[i].Scale(scale)
+			continue
+		}
+		// Handing the rows back with BackwardRows lets the optimizer update
+		// only them.
+		acc := nn.gradients[i]
+		sorted := slices.Sorted(maps.Keys(rows))
+		data := make([]float64, 0, len(sorted)*acc.Cols)
+		for _, r := range sorted {
+			data = append(data, acc.Data[r*acc.Cols:(r+1)*acc.Cols]...)
+		}
+		p.Reset()
+		p.BackwardRows(sorted, NewMatrix(len(sorted), acc.Cols, data).Scale(scale))
+	}
+	if nn.ClipValue > 0 {
+		ClipGradValue(params, nn.ClipValue)
+	}
+	if nn.ClipNorm > 0 {
+		ClipGradNorm(params, nn.ClipNorm)
+	}
+	nn.optimizer.Step(1)
+	nn.applyConstraints()
+	nn.step++
+	for i, p := range params {
+		p.Reset()
+		if rows := nn.gradientRows[i]; rows != nil {
+			acc := nn.gradients[i]
+			for r := range rows {
+				clear(acc.Data[r*acc.Cols : (r+1)*acc.Cols])
+			}
+		} else {
+			nn.gradients[i] = NewConstMatrix(p.Value.Rows, p.Value.Cols, 0)
+		}
+		nn.gradientRows[i] = map[int]bool{}
+	}
+	nn.pendingBatches, nn.pendingScale = 0, 0
+}
+
+func (nn *NeuralNetwork) applyConstraints() {
+	for _, c := range nn.Constraints {
+		c.Apply()
+	}
+}
+
+func (nn *NeuralNetwork) parameters() []*VariableNode {
+	opt, ok := nn.optimizer.(ParameterOptimizer)
+	if !ok {
+		panic(fmt.Sprintf("Optimizer %T does not implement ParameterOptimizer, which gradient accumulation and clipping need", nn.optimizer))
+	}
+	return opt.Params()
+}
+
+/*
+sampleGraphsFor returns one per-sample graph for every sample of a batch of
+the given size, building more graphs when needed.
+*/
+func (nn *NeuralNetwork) sampleGraphsFor(batchSize int) []*networkGraph {
+	for len(nn.sampleGraphs) < batchSize {
+		nn.sampleGraphs = append(nn.sampleGraphs, newNetworkGraph(nn.buildFunc()))
+	}
+	return nn.sampleGraphs[:batchSize]
+}
+
+/*
+graphFor returns the batched graph for the given batch size, building it on
+first use.
+*/
+func (nn *NeuralNetwork) graphFor(batchSize int) *networkGraph {
+	if nn.batchGraphs == nil {
+		nn.batchGraphs = make(map[int]*networkGraph)
+	}
+	g, ok := nn.batchGraphs[batchSize]
+	if !ok {
+		g = newNetworkGraph(nn.batchBuildFunc(batchSize))
+		nn.batchGraphs[batchSize] = g
+	}
+	return g
+}
+
+func (g *networkGraph) feed(inputData, targetData [][]float64) {
+	g.input.Value = NewMatrix(g.input.Value.Rows, g.input.Value.Cols, concatRows(inputData))
+	if targetData != nil {
+		g.target.Value = NewMatrix(g.target.Value.Rows, g.target.Value.Cols, concatRows(targetData))
+	}
+}
+
+func concatRows(data [][]float64) []float64 {
+	var result []float64
+	for _, row := range data {
+		result = append(result, row...)
+	}
+	return result
+}
+
+/*
+splitRows splits the data of a batched output matrix into one slice per sample.
+*/
+func splitRows(m *Matrix, batchSize int) [][]float64 {
+	size := len(m.Data) / batchSize
+	result := make([][]float64, batchSize)
+	for i := range result {
+		result[i] = append([]float64(nil), m.Data[i*size:(i+1)*size]...)
+	}
+	return result
+}
+
 func (nn *NeuralNetwork) Evaluate(inputData, targetData [][]float64) (lossValue float64, outputData [][]float64) {
+	if nn.batchBuildFunc != nil {
+		batchSize := nn.EvalBatchSize
+		if batchSize <= 0 {
+			batchSize = defaultEvalBatchSize
+		}
+		for start := 0; start < len(inputData); start += batchSize {
+			end := min(len(inputData), start+batchSize)
+			g := nn.graphFor(end - start)
+			g.feed(inputData[start:end], targetData[start:end])
+			g.setTraining(false)
+			outputData = append(outputData, splitRows(g.output.Forward(), end-start)...)
+			lossValue += g.loss.Forward().Data[0] * float64(end-start)
+			g.loss.Reset()
+		}
+		lossValue /= float64(len(inputData))
+		return
+	}
 	input, target, output, loss := nn.buildFunc()
+	SetTraining(loss, false)
 	outputData = make([][]float64, len(inputData))
 	for i := range inputData {
 		input.Value = NewMatrix(input.Value.Rows, input.Value.Cols, inputData[i])
@@ -57,7 +418,16 @@ func (nn *NeuralNetwork) Evaluate(inputData, targetData [][]float64) (lossValue
 	return
 }
 func (nn *NeuralNetwork) Predict(inputData []float64) (outputData []float64) {
+	if nn.batchBuildFunc != nil {
+		g := nn.graphFor(1)
+		g.feed([][]float64{inputData}, nil)
+		g.setTraining(false)
+		outputData = append([]float64(nil), g.output.Forward().Data...)
+		g.output.Reset()
+		return
+	}
 	input, _, output, _ := nn.buildFunc()
+	SetTraining(output, false)
 	input.Value = NewMatrix(input.Value.Rows, input.Value.Cols, inputData)
 	outputData = output.Forward().Data
 	return
@@ -71,3 +441,17 @@ func NewNeuralNetwork(
 		optimizer: optimizer,
 	}
 }
+
+/*
+NewBatchNeuralNetwork creates a network whose build function receives the
+batch size and returns a graph that processes a whole mini-batch at once.
+Graphs are built once per distinct batch size and reused.
+*/
+func NewBatchNeuralNetwork(
+	buildFunc func(batchSize int) (input, target *VariableNode, output, loss Node),
+	optimizer Optimizer) *NeuralNetwork {
+	return &NeuralNetwork{
+		batchBuildFunc: buildFunc,
+		optimizer:      optimizer,
+	}
+}
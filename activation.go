@@ -0,0 +1,673 @@
+package goraph
+
+import (
+	"math"
+	"sync"
+)
+
+func sigmoid(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
+
+/*
+softplus computes log(1+e^v) without overflowing for large v.
+*/
+func softplus(v float64) float64 {
+	return max(v, 0) + math.Log1p(math.Exp(-math.Abs(v)))
+}
+
+/*
+mapElements returns a matrix of the shape of x holding f of every element.
+*/
+func mapElements(x *Matrix, f func(x float64) float64) *Matrix {
+	data := make([]float64, len(x.Data))
+	for i, v := range x.Data {
+		data[i] = f(v)
+	}
+	return NewMatrix(x.Rows, x.Cols, data)
+}
+
+/*
+elementGradients returns the gradient with respect to x of an element-wise
+function with the value y, given the derivative df at every element.
+*/
+func elementGradients(grad, x, y *Matrix, df func(x, y float64) float64) *Matrix {
+	data := make([]float64, len(x.Data))
+	for i, v := range x.Data {
+		data[i] = grad.Data[i] * df(v, y.Data[i])
+	}
+	return NewMatrix(x.Rows, x.Cols, data)
+}
+
+/*
+LeakyReLUNode defines a node that passes positive elements of X through and
+multiplies the others by Slope.
+*/
+type LeakyReLUNode struct {
+	X          Node
+	Slope      float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func LeakyReLU(x Node, slope float64) *LeakyReLUNode {
+	return &LeakyReLUNode{
+		X:     x,
+		Slope: slope,
+	}
+}
+
+func (m *LeakyReLUNode) apply(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return m.Slope * x
+}
+
+func (m *LeakyReLUNode) derivative(x, y float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return m.Slope
+}
+
+func (m *LeakyReLUNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *LeakyReLUNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *LeakyReLUNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *LeakyReLUNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *LeakyReLUNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *LeakyReLUNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+ELUNode defines a node that passes positive elements of X through and maps
+the others to Alpha*(e^x-1).
+*/
+type ELUNode struct {
+	X          Node
+	Alpha      float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func ELU(x Node, alpha float64) *ELUNode {
+	return &ELUNode{
+		X:     x,
+		Alpha: alpha,
+	}
+}
+
+func (m *ELUNode) apply(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return m.Alpha * math.Expm1(x)
+}
+
+func (m *ELUNode) derivative(x, y float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return y + m.Alpha
+}
+
+func (m *ELUNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *ELUNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ELUNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *ELUNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *ELUNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *ELUNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+const (
+	seluAlpha = 1.6732632423543772
+	seluScale = 1.0507009873554805
+)
+
+/*
+SELUNode defines a node that computes the scaled ELU, whose constants keep
+the activations of a deep network normalized.
+*/
+type SELUNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func SELU(x Node) *SELUNode {
+	return &SELUNode{
+		X: x,
+	}
+}
+
+func (m *SELUNode) apply(x float64) float64 {
+	if x > 0 {
+		return seluScale * x
+	}
+	return seluScale * seluAlpha * math.Expm1(x)
+}
+
+func (m *SELUNode) derivative(x, y float64) float64 {
+	if x > 0 {
+		return seluScale
+	}
+	return y + seluScale*seluAlpha
+}
+
+func (m *SELUNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SELUNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SELUNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SELUNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SELUNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SELUNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+GELUNode defines a node that computes x*Φ(x), where Φ is the cumulative
+distribution function of the standard normal distribution. With Approximate
+it computes the tanh approximation 0.5*x*(1+tanh(√(2/π)*(x+0.044715*x³)))
+instead.
+*/
+type GELUNode struct {
+	X           Node
+	Approximate bool
+	Value       *Matrix
+	Name        string
+	valueMutex  sync.Mutex
+}
+
+func GELU(x Node) *GELUNode {
+	return &GELUNode{
+		X: x,
+	}
+}
+
+/*
+GELUTanh creates a GELUNode that computes the tanh approximation.
+*/
+func GELUTanh(x Node) *GELUNode {
+	return &GELUNode{
+		X:           x,
+		Approximate: true,
+	}
+}
+
+var geluTanhScale = math.Sqrt(2 / math.Pi)
+
+func (m *GELUNode) apply(x float64) float64 {
+	if m.Approximate {
+		return 0.5 * x * (1 + math.Tanh(geluTanhScale*(x+0.044715*x*x*x)))
+	}
+	return 0.5 * x * (1 + math.Erf(x/math.Sqrt2))
+}
+
+func (m *GELUNode) derivative(x, y float64) float64 {
+	if m.Approximate {
+		t := math.Tanh(geluTanhScale * (x + 0.044715*x*x*x))
+		return 0.5*(1+t) + 0.5*x*(1-t*t)*geluTanhScale*(1+3*0.044715*x*x)
+	}
+	return 0.5*(1+math.Erf(x/math.Sqrt2)) + x*math.Exp(-x*x/2)/math.Sqrt(2*math.Pi)
+}
+
+func (m *GELUNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *GELUNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *GELUNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *GELUNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *GELUNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *GELUNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+SwishNode defines a node that computes x*σ(Beta*x).
+*/
+type SwishNode struct {
+	X          Node
+	Beta       float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Swish(x Node, beta float64) *SwishNode {
+	return &SwishNode{
+		X:    x,
+		Beta: beta,
+	}
+}
+
+/*
+SiLU creates a SwishNode with a Beta of 1, which computes x*σ(x).
+*/
+func SiLU(x Node) *SwishNode {
+	return Swish(x, 1)
+}
+
+func (m *SwishNode) apply(x float64) float64 {
+	return x * sigmoid(m.Beta*x)
+}
+
+func (m *SwishNode) derivative(x, y float64) float64 {
+	s := sigmoid(m.Beta * x)
+	return s + m.Beta*x*s*(1-s)
+}
+
+func (m *SwishNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SwishNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SwishNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SwishNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SwishNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SwishNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+SoftplusNode defines a node that computes log(1+e^x), a smooth approximation
+of ReLU.
+*/
+type SoftplusNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Softplus(x Node) *SoftplusNode {
+	return &SoftplusNode{
+		X: x,
+	}
+}
+
+func (m *SoftplusNode) apply(x float64) float64 {
+	return softplus(x)
+}
+
+func (m *SoftplusNode) derivative(x, y float64) float64 {
+	return sigmoid(x)
+}
+
+func (m *SoftplusNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SoftplusNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SoftplusNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SoftplusNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SoftplusNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SoftplusNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+MishNode defines a node that computes x*tanh(softplus(x)).
+*/
+type MishNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Mish(x Node) *MishNode {
+	return &MishNode{
+		X: x,
+	}
+}
+
+func (m *MishNode) apply(x float64) float64 {
+	return x * math.Tanh(softplus(x))
+}
+
+func (m *MishNode) derivative(x, y float64) float64 {
+	t := math.Tanh(softplus(x))
+	return t + x*(1-t*t)*sigmoid(x)
+}
+
+func (m *MishNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *MishNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *MishNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *MishNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *MishNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *MishNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+HardSigmoidNode defines a node that computes the piecewise linear
+approximation of Sigmoid min(1, max(0, x/6+0.5)).
+*/
+type HardSigmoidNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func HardSigmoid(x Node) *HardSigmoidNode {
+	return &HardSigmoidNode{
+		X: x,
+	}
+}
+
+func (m *HardSigmoidNode) apply(x float64) float64 {
+	return min(1, max(0, x/6+0.5))
+}
+
+func (m *HardSigmoidNode) derivative(x, y float64) float64 {
+	if x > -3 && x < 3 {
+		return 1.0 / 6
+	}
+	return 0
+}
+
+func (m *HardSigmoidNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *HardSigmoidNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *HardSigmoidNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *HardSigmoidNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *HardSigmoidNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *HardSigmoidNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+HardTanhNode defines a node that clamps the elements of X to [Min, Max]; the
+gradient is zero outside that range.
+*/
+type HardTanhNode struct {
+	X          Node
+	Min        float64
+	Max        float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func HardTanh(x Node, minVal, maxVal float64) *HardTanhNode {
+	return &HardTanhNode{
+		X:   x,
+		Min: minVal,
+		Max: maxVal,
+	}
+}
+
+func (m *HardTanhNode) apply(x float64) float64 {
+	return min(m.Max, max(m.Min, x))
+}
+
+func (m *HardTanhNode) derivative(x, y float64) float64 {
+	if x > m.Min && x < m.Max {
+		return 1
+	}
+	return 0
+}
+
+func (m *HardTanhNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *HardTanhNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *HardTanhNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *HardTanhNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *HardTanhNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *HardTanhNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+PReLUNode defines a node that performs the parametric ReLU: positive elements
+of X pass through and the others are multiplied by Slope, a learnable
+variable that is broadcast against X, usually 1x1 for a single slope or 1xC
+for one slope per column.
+*/
+type PReLUNode struct {
+	X          Node
+	Slope      Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func PReLU(x, slope Node) *PReLUNode {
+	return &PReLUNode{
+		X:     x,
+		Slope: slope,
+	}
+}
+
+func (m *PReLUNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = m.X.Forward().broadcast(m.Slope.Forward(), func(x, a float64) float64 {
+			if x > 0 {
+				return x
+			}
+			return a * x
+		})
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *PReLUNode) Inputs() []Node {
+	return []Node{m.X, m.Slope}
+}
+func (m *PReLUNode) Gradients(grad *Matrix) []*Matrix {
+	x, slope := m.X.Forward(), m.Slope.Forward()
+	gradX := x.broadcast(slope, func(x, a float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return a
+	}).MultiElement(grad)
+	gradSlope := x.broadcast(slope, func(x, a float64) float64 {
+		if x > 0 {
+			return 0
+		}
+		return x
+	}).MultiElement(grad)
+	return []*Matrix{gradX.sumTo(x.Rows, x.Cols), gradSlope.sumTo(slope.Rows, slope.Cols)}
+}
+func (m *PReLUNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *PReLUNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Slope.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *PReLUNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
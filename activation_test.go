@@ -0,0 +1,61 @@
+package goraph
+
+import "testing"
+
+func TestActivationGradients(t *testing.T) {
+	activations := map[string]func(x Node) Node{
+		"LeakyReLU":   func(x Node) Node { return LeakyReLU(x, 0.1) },
+		"ELU":         func(x Node) Node { return ELU(x, 1.5) },
+		"SELU":        func(x Node) Node { return SELU(x) },
+		"GELU":        func(x Node) Node { return GELU(x) },
+		"GELUTanh":    func(x Node) Node { return GELUTanh(x) },
+		"Swish":       func(x Node) Node { return Swish(x, 1.7) },
+		"SiLU":        func(x Node) Node { return SiLU(x) },
+		"Softplus":    func(x Node) Node { return Softplus(x) },
+		"Mish":        func(x Node) Node { return Mish(x) },
+		"HardSigmoid": func(x Node) Node { return HardSigmoid(x) },
+		"HardTanh":    func(x Node) Node { return HardTanh(x, -0.5, 0.5) },
+	}
+	for name, activation := range activations {
+		t.Run(name, func(t *testing.T) {
+			r := testRand()
+			x := NewRandomVariable(4, 5, func() float64 { return r.Float64()*8 - 4 })
+			checkGradients(t, project(r, activation(x), 4, 5), x)
+		})
+	}
+}
+
+func TestActivationParameters(t *testing.T) {
+	x := NewConstVariable(1, 1, 0)
+	if slope := LeakyReLU(x, 0.1).Slope; slope != 0.1 {
+		t.Errorf("LeakyReLU Slope is %g, want 0.1", slope)
+	}
+	if alpha := ELU(x, 1.5).Alpha; alpha != 1.5 {
+		t.Errorf("ELU Alpha is %g, want 1.5", alpha)
+	}
+	if GELU(x).Approximate || !GELUTanh(x).Approximate {
+		t.Error("only GELUTanh is Approximate")
+	}
+	if beta := SiLU(x).Beta; beta != 1 {
+		t.Errorf("SiLU Beta is %g, want 1", beta)
+	}
+	if h := HardTanh(x, -0.5, 0.5); h.Min != -0.5 || h.Max != 0.5 {
+		t.Errorf("HardTanh range is [%g, %g], want [-0.5, 0.5]", h.Min, h.Max)
+	}
+}
+
+func TestActivationGradientsBeforeForward(t *testing.T) {
+	x := NewVariable(1, 2, []float64{-1, 2})
+	grads := ELU(x, 1).Gradients(NewConstMatrix(1, 2, 1))
+	if grads[0].Data[1] != 1 || grads[0].Data[0] <= 0 {
+		t.Fatalf("gradients %v", grads[0].Data)
+	}
+}
+
+func TestPReLUGradients(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 4, 3)
+	for _, slope := range []*VariableNode{randomVariable(r, 1, 1), randomVariable(r, 1, 3)} {
+		checkGradients(t, project(r, PReLU(x, slope), 4, 3), x, slope)
+	}
+}
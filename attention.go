@@ -0,0 +1,325 @@
+package goraph
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+/*
+AttentionNode defines a node that performs multi-head scaled dot-product
+attention. The rows of Q are the query positions and the rows of K and V the
+key positions, so for self-attention over a sequence laid out one position
+per row, Q, K and V are all projections of the same matrix. The columns of
+Q, K and V are split evenly into Heads heads; every head computes
+softmax(Qh·Khᵀ/√dk)·Vh, where dk is the number of columns of Qh, and the
+results of the heads are concatenated side by side.
+
+Mask is optional. It is a 1xS or TxS matrix, for S key positions and T query
+positions, whose zero elements mark keys a query must not attend to, such as
+padding. With Causal set, position i also does not attend to positions after
+it. A query that may attend to no key at all gets a result of zeros.
+*/
+type AttentionNode struct {
+	Q          Node
+	K          Node
+	V          Node
+	Mask       Node
+	Heads      int
+	Causal     bool
+	Value      *Matrix
+	Name       string
+	weights    []*Matrix
+	valueMutex sync.Mutex
+}
+
+func Attention(q, k, v, mask Node, heads int, causal bool) *AttentionNode {
+	return &AttentionNode{
+		Q:      q,
+		K:      k,
+		V:      v,
+		Mask:   mask,
+		Heads:  heads,
+		Causal: causal,
+	}
+}
+
+/*
+masked reports whether query i must not attend to key j.
+*/
+func (m *AttentionNode) masked(mask *Matrix, i, j int) bool {
+	if m.Causal && j > i {
+		return true
+	}
+	return mask != nil && mask.Data[(i%mask.Rows)*mask.Cols+j] == 0
+}
+
+func (m *AttentionNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		q, k, v := m.Q.Forward(), m.K.Forward(), m.V.Forward()
+		if q.Cols != k.Cols || k.Rows != v.Rows || q.Cols%m.Heads != 0 || v.Cols%m.Heads != 0 {
+			panic("Attention dimensions do not match")
+		}
+		var mask *Matrix
+		if m.Mask != nil {
+			mask = m.Mask.Forward()
+			if mask.Cols != k.Rows || (mask.Rows != 1 && mask.Rows != q.Rows) {
+				panic("Attention mask dimensions do not match")
+			}
+		}
+		dk, dv := q.Cols/m.Heads, v.Cols/m.Heads
+		scale := 1 / math.Sqrt(float64(dk))
+		m.weights = make([]*Matrix, m.Heads)
+		var value *Matrix
+		for h := range m.Heads {
+			scores := q.ColSlice(h*dk, (h+1)*dk).Multi(k.ColSlice(h*dk, (h+1)*dk).Trans())
+			weights := NewConstMatrix(scores.Rows, scores.Cols, 0)
+			for i := range scores.Rows {
+				maxVal := math.Inf(-1)
+				for j := range scores.Cols {
+					if !m.masked(mask, i, j) {
+						maxVal = math.Max(maxVal, scores.Data[i*scores.Cols+j]*scale)
+					}
+				}
+				if math.IsInf(maxVal, -1) {
+					continue
+				}
+				sum := 0.0
+				for j := range scores.Cols {
+					if !m.masked(mask, i, j) {
+						weights.Data[i*scores.Cols+j] = math.Exp(scores.Data[i*scores.Cols+j]*scale - maxVal)
+						sum += weights.Data[i*scores.Cols+j]
+					}
+				}
+				for j := range scores.Cols {
+					weights.Data[i*scores.Cols+j] /= sum
+				}
+			}
+			m.weights[h] = weights
+			head := weights.Multi(v.ColSlice(h*dv, (h+1)*dv))
+			if value == nil {
+				value = head
+			} else {
+				value = value.HConcat(head)
+			}
+		}
+		m.Value = value
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+
+/*
+Weights returns the attention weights of every head computed by the last
+forward pass, one row per query position and one column per key position.
+*/
+func (m *AttentionNode) Weights() []*Matrix {
+	return m.weights
+}
+
+func (m *AttentionNode) Inputs() []Node {
+	if m.Mask == nil {
+		return []Node{m.Q, m.K, m.V}
+	}
+	return []Node{m.Q, m.K, m.V, m.Mask}
+}
+func (m *AttentionNode) Gradients(grad *Matrix) []*Matrix {
+	q, k, v := m.Q.Forward(), m.K.Forward(), m.V.Forward()
+	dk, dv := q.Cols/m.Heads, v.Cols/m.Heads
+	scale := 1 / math.Sqrt(float64(dk))
+	var gradQ, gradK, gradV *Matrix
+	for h := range m.Heads {
+		weights := m.weights[h]
+		gradHead := grad.ColSlice(h*dv, (h+1)*dv)
+		gradWeights := gradHead.Multi(v.ColSlice(h*dv, (h+1)*dv).Trans())
+		gradScores := NewConstMatrix(weights.Rows, weights.Cols, 0)
+		for i := range weights.Rows {
+			dot := 0.0
+			for j := range weights.Cols {
+				dot += gradWeights.Data[i*weights.Cols+j] * weights.Data[i*weights.Cols+j]
+			}
+			for j := range weights.Cols {
+				idx := i*weights.Cols + j
+				gradScores.Data[idx] = weights.Data[idx] * (gradWeights.Data[idx] - dot) * scale
+			}
+		}
+		gradQh := gradScores.Multi(k.ColSlice(h*dk, (h+1)*dk))
+		gradKh := gradScores.Trans().Multi(q.ColSlice(h*dk, (h+1)*dk))
+		gradVh := weights.Trans().Multi(gradHead)
+		if h == 0 {
+			gradQ, gradK, gradV = gradQh, gradKh, gradVh
+		} else {
+			gradQ, gradK, gradV = gradQ.HConcat(gradQh), gradK.HConcat(gradKh), gradV.HConcat(gradVh)
+		}
+	}
+	grads := []*Matrix{gradQ, gradK, gradV}
+	if m.Mask != nil {
+		grads = append(grads, nil)
+	}
+	return grads
+}
+func (m *AttentionNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *AttentionNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.Q.Reset()
+		m.K.Reset()
+		m.V.Reset()
+		if m.Mask != nil {
+			m.Mask.Reset()
+		}
+	}
+	m.valueMutex.Unlock()
+}
+func (m *AttentionNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+dense creates the weights of a fully connected layer, Xavier initialized, and
+its bias initialized to zeros.
+*/
+func dense(inputSize, outputSize int, r *rand.Rand) (w, b *VariableNode) {
+	w = NewRandomVariable(inputSize, outputSize, NewXavierUniformInitWithRand(inputSize, outputSize, r))
+	b = NewConstVariable(1, outputSize, 0)
+	return
+}
+
+/*
+MultiHeadAttention holds the parameters of a multi-head attention layer: the
+projections of the queries, keys and values, and the projection of the
+concatenated heads back to the model size.
+*/
+type MultiHeadAttention struct {
+	WQ     *VariableNode
+	BQ     *VariableNode
+	WK     *VariableNode
+	BK     *VariableNode
+	WV     *VariableNode
+	BV     *VariableNode
+	WO     *VariableNode
+	BO     *VariableNode
+	Heads  int
+	Causal bool
+}
+
+/*
+NewMultiHeadAttention creates a multi-head attention layer over inputs of
+modelSize columns, which must be a multiple of heads.
+*/
+func NewMultiHeadAttention(modelSize, heads int, r *rand.Rand) *MultiHeadAttention {
+	if modelSize%heads != 0 {
+		panic("Model size is not a multiple of the number of heads")
+	}
+	a := &MultiHeadAttention{Heads: heads}
+	a.WQ, a.BQ = dense(modelSize, modelSize, r)
+	a.WK, a.BK = dense(modelSize, modelSize, r)
+	a.WV, a.BV = dense(modelSize, modelSize, r)
+	a.WO, a.BO = dense(modelSize, modelSize, r)
+	return a
+}
+
+/*
+Attend builds the nodes of the layer that let the rows of query attend to the
+rows of key and value, with an optional mask as described for AttentionNode.
+For self-attention, query, key and value are the same node.
+*/
+func (a *MultiHeadAttention) Attend(query, key, value, mask Node) Node {
+	q := Add(Multi(query, a.WQ), a.BQ)
+	k := Add(Multi(key, a.WK), a.BK)
+	v := Add(Multi(value, a.WV), a.BV)
+	return Add(Multi(Attention(q, k, v, mask, a.Heads, a.Causal), a.WO), a.BO)
+}
+
+func (a *MultiHeadAttention) Parameters() []*VariableNode {
+	return []*VariableNode{a.WQ, a.BQ, a.WK, a.BK, a.WV, a.BV, a.WO, a.BO}
+}
+
+/*
+TransformerEncoder holds the parameters of a Transformer encoder block:
+self-attention followed by a feed-forward network of two layers with
+Activation in between, each wrapped in a residual connection and a layer
+normalization. A nil Activation selects GELU. By default the normalization
+follows the residual connection, as in the original Transformer; with PreNorm
+it is applied to the input of each sub-layer instead, which often trains more
+stably in deep stacks.
+*/
+type TransformerEncoder struct {
+	Attention  *MultiHeadAttention
+	W1         *VariableNode
+	B1         *VariableNode
+	W2         *VariableNode
+	B2         *VariableNode
+	Gamma1     *VariableNode
+	Beta1      *VariableNode
+	Gamma2     *VariableNode
+	Beta2      *VariableNode
+	Activation func(x Node) Node
+	PreNorm    bool
+}
+
+/*
+NewTransformerEncoder creates an encoder block over inputs of modelSize
+columns, with the given number of attention heads and hidden units of the
+feed-forward network.
+*/
+func NewTransformerEncoder(modelSize, heads, feedForwardSize int, r *rand.Rand) *TransformerEncoder {
+	e := &TransformerEncoder{Attention: NewMultiHeadAttention(modelSize, heads, r)}
+	e.W1, e.B1 = dense(modelSize, feedForwardSize, r)
+	e.W2, e.B2 = dense(feedForwardSize, modelSize, r)
+	e.Gamma1, e.Beta1 = NewConstVariable(1, modelSize, 1), NewConstVariable(1, modelSize, 0)
+	e.Gamma2, e.Beta2 = NewConstVariable(1, modelSize, 1), NewConstVariable(1, modelSize, 0)
+	return e
+}
+
+/*
+Encode builds the nodes of the block for x, one sequence position per row,
+with an optional mask as described for AttentionNode.
+*/
+func (e *TransformerEncoder) Encode(x, mask Node) Node {
+	activation := e.Activation
+	if activation == nil {
+		activation = func(x Node) Node { return GELU(x) }
+	}
+	feedForward := func(x Node) Node {
+		return Add(Multi(activation(Add(Multi(x, e.W1), e.B1)), e.W2), e.B2)
+	}
+	if e.PreNorm {
+		h := LayerNorm(x, e.Gamma1, e.Beta1)
+		x = Add(x, e.Attention.Attend(h, h, h, mask))
+		return Add(x, feedForward(LayerNorm(x, e.Gamma2, e.Beta2)))
+	}
+	x = LayerNorm(Add(x, e.Attention.Attend(x, x, x, mask)), e.Gamma1, e.Beta1)
+	return LayerNorm(Add(x, feedForward(x)), e.Gamma2, e.Beta2)
+}
+
+func (e *TransformerEncoder) Parameters() []*VariableNode {
+	return append(e.Attention.Parameters(), e.W1, e.B1, e.W2, e.B2, e.Gamma1, e.Beta1, e.Gamma2, e.Beta2)
+}
+
+/*
+PositionalEncoding returns the sinusoidal position encodings of the original
+Transformer for a sequence of the given length, one position per row: column
+2i holds sin(p/10000^(2i/size)) and column 2i+1 the matching cosine. Adding
+it to the input of an encoder lets attention take the order of the positions
+into account.
+*/
+func PositionalEncoding(length, size int) *Matrix {
+	data := make([]float64, length*size)
+	for p := range length {
+		for i := range size {
+			angle := float64(p) / math.Pow(10000, float64(i-i%2)/float64(size))
+			if i%2 == 0 {
+				data[p*size+i] = math.Sin(angle)
+			} else {
+				data[p*size+i] = math.Cos(angle)
+			}
+		}
+	}
+	return NewMatrix(length, size, data)
+}
@@ -0,0 +1,71 @@
+package goraph
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAttentionGradients(t *testing.T) {
+	r := testRand()
+	q, k, v := randomVariable(r, 4, 6), randomVariable(r, 5, 6), randomVariable(r, 5, 4)
+	mask := NewVariable(1, 5, []float64{1, 1, 0, 1, 0})
+	t.Run("one head", func(t *testing.T) {
+		checkGradients(t, project(r, Attention(q, k, v, nil, 1, false), 4, 4), q, k, v)
+	})
+	t.Run("two heads", func(t *testing.T) {
+		checkGradients(t, project(r, Attention(q, k, v, nil, 2, false), 4, 4), q, k, v)
+	})
+	t.Run("mask", func(t *testing.T) {
+		checkGradients(t, project(r, Attention(q, k, v, mask, 2, false), 4, 4), q, k, v)
+	})
+	t.Run("causal", func(t *testing.T) {
+		x := randomVariable(r, 5, 6)
+		checkGradients(t, project(r, Attention(x, x, x, nil, 3, true), 5, 6), x)
+	})
+	t.Run("fully masked row", func(t *testing.T) {
+		x := randomVariable(r, 5, 6)
+		rowMask := NewVariable(2, 5, []float64{0, 0, 0, 0, 0, 1, 1, 1, 1, 1})
+		a := Attention(RowSlice(x, 0, 2), x, x, rowMask, 1, false)
+		for _, value := range a.Forward().Data[:6] {
+			if value != 0 {
+				t.Fatalf("fully masked row gives %v, want zeros", a.Value.Data[:6])
+			}
+		}
+		checkGradients(t, project(r, a, 2, 6), x)
+	})
+}
+
+func TestMultiHeadAttentionGradients(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 4, 6)
+	a := NewMultiHeadAttention(6, 2, r)
+	checkGradients(t, project(r, a.Attend(x, x, x, nil), 4, 6), append(a.Parameters(), x)...)
+}
+
+func TestTransformerEncoderGradients(t *testing.T) {
+	for _, preNorm := range []bool{false, true} {
+		r := testRand()
+		x := randomVariable(r, 5, 6)
+		mask := NewVariable(1, 5, []float64{1, 1, 0, 1, 1})
+		e := NewTransformerEncoder(6, 2, 8, r)
+		e.PreNorm = preNorm
+		checkGradients(t, project(r, e.Encode(x, mask), 5, 6), append(e.Parameters(), x)...)
+	}
+}
+
+func TestTransformerEncoderActivation(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 3, 4)
+	e := NewTransformerEncoder(4, 2, 6, r)
+	defaultOutput := e.Encode(x, nil).Forward()
+	e.Activation = func(x Node) Node { return GELU(x) }
+	if gelu := e.Encode(x, nil).Forward(); !slices.Equal(gelu.Data, defaultOutput.Data) {
+		t.Fatal("the default activation is not GELU")
+	}
+	e.Activation = func(x Node) Node { return Softplus(x) }
+	encoded := e.Encode(x, nil)
+	if slices.Equal(encoded.Forward().Data, defaultOutput.Data) {
+		t.Fatal("the activation is not used")
+	}
+	checkGradients(t, project(r, encoded, 3, 4), append(e.Parameters(), x)...)
+}
@@ -0,0 +1,133 @@
+package goraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+)
+
+/*
+Checkpoint is the complete state of a training: the model with its
+parameters, scalers and scheduler, the state of the optimizer, the number of
+finished epochs and optimization steps, and the state of the random source
+that shuffles the training samples. When it is saved in the middle of a
+gradient accumulation, it also holds the gradients accumulated so far, one per
+parameter, along with the number of batches and samples they cover, so that
+the resumed training takes the same step the interrupted one would have.
+*/
+type Checkpoint struct {
+	Model          *Model          `json:"model"`
+	Optimizer      json.RawMessage `json:"optimizer,omitempty"`
+	Epoch          int             `json:"epoch"`
+	Step           int             `json:"step"`
+	Rand           []byte          `json:"rand,omitempty"`
+	Gradients      []*Matrix       `json:"gradients,omitempty"`
+	PendingBatches int             `json:"pending_batches,omitempty"`
+	PendingScale   int             `json:"pending_scale,omitempty"`
+}
+
+/*
+SaveCheckpoint saves the training state of the network together with model,
+which must hold the parameters trained by the optimizer of the network. The
+optimizer state is only saved if the optimizer implements StatefulOptimizer.
+The file is replaced atomically, so an interruption while saving leaves the
+previous checkpoint intact.
+*/
+func (nn *NeuralNetwork) SaveCheckpoint(filePath string, model *Model) error {
+	checkpoint := Checkpoint{
+		Model: model,
+		Epoch: nn.epoch,
+		Step:  nn.step,
+	}
+	if opt, ok := nn.optimizer.(StatefulOptimizer); ok {
+		state, err := opt.MarshalState()
+		if err != nil {
+			return err
+		}
+		checkpoint.Optimizer = state
+	}
+	if nn.source != nil {
+		state, err := nn.source.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		checkpoint.Rand = state
+	}
+	if nn.pendingBatches > 0 {
+		checkpoint.Gradients = nn.gradients
+		checkpoint.PendingBatches, checkpoint.PendingScale = nn.pendingBatches, nn.pendingScale
+	}
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := json.NewEncoder(file).Encode(&checkpoint); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+/*
+Resume restores a checkpoint saved by SaveCheckpoint. The parameters, scalers
+and scheduler are decoded into model as by Model.Load, the optimizer state
+into the optimizer of the network, and the counters, random source and
+accumulated gradients into the network, so Trainer.Fit continues with the
+epoch after the saved one.
+*/
+func (nn *NeuralNetwork) Resume(filePath string, model *Model) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	checkpoint := Checkpoint{Model: model}
+	if err := json.NewDecoder(file).Decode(&checkpoint); err != nil {
+		return err
+	}
+	if checkpoint.Optimizer != nil {
+		opt, ok := nn.optimizer.(StatefulOptimizer)
+		if !ok {
+			return fmt.Errorf("optimizer %T cannot restore the saved optimizer state", nn.optimizer)
+		}
+		if err := opt.UnmarshalState(checkpoint.Optimizer); err != nil {
+			return err
+		}
+	}
+	if model.Scheduler != nil {
+		model.Scheduler.Apply()
+	}
+	if checkpoint.Rand != nil {
+		source := &rand.PCG{}
+		if err := source.UnmarshalBinary(checkpoint.Rand); err != nil {
+			return err
+		}
+		nn.source, nn.rand = source, rand.New(source)
+	}
+	nn.gradients, nn.gradientRows, nn.pendingBatches, nn.pendingScale = nil, nil, 0, 0
+	if checkpoint.PendingBatches > 0 {
+		params := nn.parameters()
+		if len(checkpoint.Gradients) != len(params) {
+			return fmt.Errorf("checkpoint has %d accumulated gradients for %d parameters", len(checkpoint.Gradients), len(params))
+		}
+		for i, p := range params {
+			g := checkpoint.Gradients[i]
+			if g == nil || g.Rows != p.Value.Rows || g.Cols != p.Value.Cols {
+				return fmt.Errorf("accumulated gradient %d does not match its parameter", i)
+			}
+		}
+		// The rows that have a gradient are not saved, so the restored
+		// gradients count as dense until the next step.
+		nn.gradients = checkpoint.Gradients
+		nn.gradientRows = make([]map[int]bool, len(params))
+		nn.pendingBatches, nn.pendingScale = checkpoint.PendingBatches, checkpoint.PendingScale
+	}
+	nn.epoch, nn.step = checkpoint.Epoch, checkpoint.Step
+	return nil
+}
@@ -0,0 +1,47 @@
+package goraph
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+/*
+newCheckpointNetwork builds a linear network that accumulates the gradients of
+two batches per step.
+*/
+func newCheckpointNetwork() (*NeuralNetwork, *Model, *VariableNode) {
+	w := NewVariable(2, 1, []float64{0.5, -0.5})
+	optimizer := NewAdamOptimizer([]*VariableNode{w}, 0.1, 0.9, 0.999, 1e-8)
+	nn := NewBatchNeuralNetwork(func(n int) (*VariableNode, *VariableNode, Node, Node) {
+		x := NewConstVariable(n, 2, 0)
+		y := NewConstVariable(n, 1, 0)
+		output := Multi(x, w)
+		return x, y, output, MSELoss(output, y)
+	}, optimizer)
+	nn.AccumulationSteps = 2
+	return nn, NewModel([]*VariableNode{w}, nil, nil), w
+}
+
+func TestCheckpointMidAccumulation(t *testing.T) {
+	inputs := [][][]float64{{{1, 2}, {3, 1}}, {{-1, 2}, {0, 1}}}
+	targets := [][][]float64{{{1}, {2}}, {{0}, {-1}}}
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	nn, model, w := newCheckpointNetwork()
+	nn.TrainBatch(inputs[0], targets[0])
+	if err := nn.SaveCheckpoint(path, model); err != nil {
+		t.Fatal(err)
+	}
+	nn.TrainBatch(inputs[1], targets[1])
+
+	resumed, resumedModel, resumedW := newCheckpointNetwork()
+	if err := resumed.Resume(path, resumedModel); err != nil {
+		t.Fatal(err)
+	}
+	resumed.TrainBatch(inputs[1], targets[1])
+	for i := range w.Value.Data {
+		if w.Value.Data[i] != resumedW.Value.Data[i] {
+			t.Fatalf("resumed parameters %v, want %v", resumedW.Value.Data, w.Value.Data)
+		}
+	}
+}
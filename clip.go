@@ -0,0 +1,51 @@
+package goraph
+
+import "math"
+
+/*
+ClipGradNorm rescales the gradients of the parameters together so that their
+global L2 norm, taken over all parameters as if they were one vector, is at
+most maxNorm. It returns the norm before clipping. Frozen parameters are
+ignored. The gradients are scaled in place, visiting only the rows of a
+gradient that was delivered with BackwardRows, so the optimizers can still
+update just those rows.
+*/
+func ClipGradNorm(parameters []*VariableNode, maxNorm float64) float64 {
+	sum := 0.0
+	for _, p := range parameters {
+		if p.Frozen {
+			continue
+		}
+		for _, v := range gradientElements(p) {
+			sum += v * v
+		}
+	}
+	norm := math.Sqrt(sum)
+	if norm > maxNorm {
+		scale := maxNorm / norm
+		for _, p := range parameters {
+			if !p.Frozen {
+				for i := range gradientElements(p) {
+					p.Gradient.Data[i] *= scale
+				}
+			}
+		}
+	}
+	return norm
+}
+
+/*
+ClipGradValue clips every element of the gradients of the parameters to
+[-clipValue, clipValue], in place and, like ClipGradNorm, only in the rows
+that have a gradient. Frozen parameters are ignored.
+*/
+func ClipGradValue(parameters []*VariableNode, clipValue float64) {
+	for _, p := range parameters {
+		if p.Frozen {
+			continue
+		}
+		for i, v := range gradientElements(p) {
+			p.Gradient.Data[i] = math.Max(-clipValue, math.Min(clipValue, v))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package goraph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClipGradNorm(t *testing.T) {
+	a := NewVariable(1, 2, []float64{0, 0})
+	b := NewVariable(2, 1, []float64{0, 0})
+	frozen := NewVariable(1, 1, []float64{0})
+	frozen.Freeze()
+	a.Gradient = NewMatrix(1, 2, []float64{3, 0})
+	b.Gradient = NewMatrix(2, 1, []float64{0, -4})
+	frozen.Gradient = NewMatrix(1, 1, []float64{100})
+	params := []*VariableNode{a, b, frozen}
+
+	// The global norm of [3, 0, 0, -4] is 5, so every gradient is scaled by
+	// 2/5, and the frozen gradient neither counts nor changes.
+	if norm := ClipGradNorm(params, 2); norm != 5 {
+		t.Fatalf("norm before clipping %g, want 5", norm)
+	}
+	checkValues(t, "a", a.Gradient.Data, []float64{1.2, 0})
+	checkValues(t, "b", b.Gradient.Data, []float64{0, -1.6})
+	if frozen.Gradient.Data[0] != 100 {
+		t.Fatalf("frozen gradient changed to %g", frozen.Gradient.Data[0])
+	}
+	if norm := ClipGradNorm(params, 2); math.Abs(norm-2) > 1e-12 {
+		t.Fatalf("norm after clipping %g, want 2", norm)
+	}
+
+	// A norm below the maximum is left unchanged.
+	ClipGradNorm(params, 10)
+	checkValues(t, "unclipped", a.Gradient.Data, []float64{1.2, 0})
+}
+
+func TestClipGradValue(t *testing.T) {
+	a := NewVariable(1, 4, []float64{0, 0, 0, 0})
+	frozen := NewVariable(1, 1, []float64{0})
+	frozen.Freeze()
+	a.Gradient = NewMatrix(1, 4, []float64{-3, 0.5, 2, -0.25})
+	frozen.Gradient = NewMatrix(1, 1, []float64{-7})
+	ClipGradValue([]*VariableNode{a, frozen}, 1)
+	checkValues(t, "clipped", a.Gradient.Data, []float64{-1, 0.5, 1, -0.25})
+	if frozen.Gradient.Data[0] != -7 {
+		t.Fatalf("frozen gradient changed to %g", frozen.Gradient.Data[0])
+	}
+}
@@ -0,0 +1,271 @@
+package goraph
+
+import "sync"
+
+type PaddingMode int
+
+const (
+	PaddingValid PaddingMode = iota
+	PaddingSame
+	PaddingExplicit
+)
+
+/*
+Padding defines how the borders of the input are padded with zeros before a
+convolution. With PaddingValid no padding is added, with PaddingSame the
+padding is chosen so that the output size is ceil(input size / stride), and
+with PaddingExplicit the Top, Bottom, Left and Right sizes are used as given.
+*/
+type Padding struct {
+	Mode   PaddingMode `json:"mode"`
+	Top    int         `json:"top"`
+	Bottom int         `json:"bottom"`
+	Left   int         `json:"left"`
+	Right  int         `json:"right"`
+}
+
+func ValidPadding() Padding {
+	return Padding{Mode: PaddingValid}
+}
+
+func SamePadding() Padding {
+	return Padding{Mode: PaddingSame}
+}
+
+func ExplicitPadding(top, bottom, left, right int) Padding {
+	return Padding{
+		Mode:   PaddingExplicit,
+		Top:    top,
+		Bottom: bottom,
+		Left:   left,
+		Right:  right,
+	}
+}
+
+/*
+resolve returns the padding sizes for an input of the given size along one
+axis. The extra element of an odd "same" padding goes to the end.
+*/
+func (p Padding) resolve(size, kernelSize, stride, dilation, before, after int) (int, int) {
+	switch p.Mode {
+	case PaddingSame:
+		out := (size + stride - 1) / stride
+		total := max((out-1)*stride+(kernelSize-1)*dilation+1-size, 0)
+		return total / 2, total - total/2
+	case PaddingExplicit:
+		return before, after
+	default:
+		return 0, 0
+	}
+}
+
+/*
+Conv2DNode defines a node that performs a multi-channel two-dimensional
+convolution (cross-correlation). Images are stored channel by channel: the
+value of X is a matrix of shape [N*C_in, H*W] holding N images of C_in
+channels, each channel flattened row by row. The kernel is a matrix of shape
+[C_out, C_in*kH*kW], which is the flattened form of a [C_out,C_in,kH,kW]
+tensor, and the optional bias holds one value per output channel. The result
+has shape [N*C_out, outH*outW].
+*/
+type Conv2DNode struct {
+	X            Node
+	Kernel       Node
+	Bias         Node
+	InChannels   int
+	Height       int
+	Width        int
+	OutChannels  int
+	KernelHeight int
+	KernelWidth  int
+	Stride       int
+	Dilation     int
+	Padding      Padding
+	OutHeight    int
+	OutWidth     int
+	Value        *Matrix
+	Name         string
+	valueMutex   sync.Mutex
+}
+
+/*
+Conv2D creates a convolution node for inputs of shape inShape = [C_in,H,W] and
+a kernel of shape kernelShape = [C_out,C_in,kH,kW]. The bias may be nil.
+*/
+func Conv2D(x, kernel, bias Node, inShape, kernelShape []int, stride, dilation int, padding Padding) *Conv2DNode {
+	if len(inShape) != 3 || len(kernelShape) != 4 {
+		panic("Input shape must be [C_in,H,W] and kernel shape must be [C_out,C_in,kH,kW]")
+	}
+	if inShape[0] != kernelShape[1] {
+		panic("Input channels do not match kernel channels")
+	}
+	if stride < 1 || dilation < 1 {
+		panic("Stride and dilation must be positive")
+	}
+	m := &Conv2DNode{
+		X:            x,
+		Kernel:       kernel,
+		Bias:         bias,
+		InChannels:   inShape[0],
+		Height:       inShape[1],
+		Width:        inShape[2],
+		OutChannels:  kernelShape[0],
+		KernelHeight: kernelShape[2],
+		KernelWidth:  kernelShape[3],
+		Stride:       stride,
+		Dilation:     dilation,
+	}
+	m.Padding = Padding{Mode: padding.Mode}
+	m.Padding.Top, m.Padding.Bottom = padding.resolve(m.Height, m.KernelHeight, stride, dilation, padding.Top, padding.Bottom)
+	m.Padding.Left, m.Padding.Right = padding.resolve(m.Width, m.KernelWidth, stride, dilation, padding.Left, padding.Right)
+	m.OutHeight = (m.Height+m.Padding.Top+m.Padding.Bottom-(m.KernelHeight-1)*dilation-1)/stride + 1
+	m.OutWidth = (m.Width+m.Padding.Left+m.Padding.Right-(m.KernelWidth-1)*dilation-1)/stride + 1
+	if m.OutHeight < 1 || m.OutWidth < 1 {
+		panic("Kernel is larger than the padded input")
+	}
+	return m
+}
+
+/*
+OutShape returns the shape [C_out,outH,outW] of a single output image.
+*/
+func (m *Conv2DNode) OutShape() []int {
+	return []int{m.OutChannels, m.OutHeight, m.OutWidth}
+}
+
+func (m *Conv2DNode) batchSize(x *Matrix) int {
+	if x.Cols != m.Height*m.Width || x.Rows%m.InChannels != 0 {
+		panic("Input dimensions do not match the convolution input shape")
+	}
+	return x.Rows / m.InChannels
+}
+
+/*
+columns unfolds the n-th image of x into a matrix of shape
+[C_in*kH*kW, outH*outW], where each column holds the input values covered by
+the kernel at one output position.
+*/
+func (m *Conv2DNode) columns(x *Matrix, n int) *Matrix {
+	outSize := m.OutHeight * m.OutWidth
+	cols := NewConstMatrix(m.InChannels*m.KernelHeight*m.KernelWidth, outSize, 0)
+	m.eachTap(func(c, kr, kc, or, oc, row, col int) {
+		cols.Data[((c*m.KernelHeight+kr)*m.KernelWidth+kc)*outSize+or*m.OutWidth+oc] =
+			x.Data[(n*m.InChannels+c)*x.Cols+row*m.Width+col]
+	})
+	return cols
+}
+
+/*
+eachTap calls f for every pair of kernel position (c, kr, kc) and output
+position (or, oc) that covers an input pixel (row, col) inside the image.
+*/
+func (m *Conv2DNode) eachTap(f func(c, kr, kc, or, oc, row, col int)) {
+	for c := range m.InChannels {
+		for kr := range m.KernelHeight {
+			for kc := range m.KernelWidth {
+				for or := range m.OutHeight {
+					row := or*m.Stride + kr*m.Dilation - m.Padding.Top
+					if row < 0 || row >= m.Height {
+						continue
+					}
+					for oc := range m.OutWidth {
+						col := oc*m.Stride + kc*m.Dilation - m.Padding.Left
+						if col < 0 || col >= m.Width {
+							continue
+						}
+						f(c, kr, kc, or, oc, row, col)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (m *Conv2DNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		kernel := m.Kernel.Forward()
+		if kernel.Rows != m.OutChannels || kernel.Cols != m.InChannels*m.KernelHeight*m.KernelWidth {
+			panic("Kernel dimensions do not match the kernel shape")
+		}
+		var bias *Matrix
+		if m.Bias != nil {
+			bias = m.Bias.Forward()
+			if len(bias.Data) != m.OutChannels {
+				panic("Bias size does not match the output channels")
+			}
+		}
+		batchSize := m.batchSize(x)
+		outSize := m.OutHeight * m.OutWidth
+		data := make([]float64, 0, batchSize*m.OutChannels*outSize)
+		for n := range batchSize {
+			out := kernel.Multi(m.columns(x, n))
+			if bias != nil {
+				for c := range m.OutChannels {
+					for i := range outSize {
+						out.Data[c*outSize+i] += bias.Data[c]
+					}
+				}
+			}
+			data = append(data, out.Data...)
+		}
+		m.Value = NewMatrix(batchSize*m.OutChannels, outSize, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *Conv2DNode) Inputs() []Node {
+	if m.Bias == nil {
+		return []Node{m.X, m.Kernel}
+	}
+	return []Node{m.X, m.Kernel, m.Bias}
+}
+func (m *Conv2DNode) Gradients(grad *Matrix) []*Matrix {
+	x := m.X.Forward()
+	kernel := m.Kernel.Forward()
+	batchSize := m.batchSize(x)
+	outSize := m.OutHeight * m.OutWidth
+	xGrad := NewConstMatrix(x.Rows, x.Cols, 0)
+	kernelGrad := NewConstMatrix(kernel.Rows, kernel.Cols, 0)
+	kernelTrans := kernel.Trans()
+	for n := range batchSize {
+		gradN := grad.RowSlice(n*m.OutChannels, (n+1)*m.OutChannels)
+		kernelGrad = kernelGrad.Add(gradN.Multi(m.columns(x, n).Trans()))
+		colsGrad := kernelTrans.Multi(gradN)
+		m.eachTap(func(c, kr, kc, or, oc, row, col int) {
+			xGrad.Data[(n*m.InChannels+c)*x.Cols+row*m.Width+col] +=
+				colsGrad.Data[((c*m.KernelHeight+kr)*m.KernelWidth+kc)*outSize+or*m.OutWidth+oc]
+		})
+	}
+	if m.Bias == nil {
+		return []*Matrix{xGrad, kernelGrad}
+	}
+	bias := m.Bias.Forward()
+	biasGrad := NewConstMatrix(bias.Rows, bias.Cols, 0)
+	for r := range grad.Rows {
+		for i := range outSize {
+			biasGrad.Data[r%m.OutChannels] += grad.Data[r*outSize+i]
+		}
+	}
+	return []*Matrix{xGrad, kernelGrad, biasGrad}
+}
+func (m *Conv2DNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *Conv2DNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Kernel.Reset()
+		if m.Bias != nil {
+			m.Bias.Reset()
+		}
+	}
+	m.valueMutex.Unlock()
+}
+func (m *Conv2DNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
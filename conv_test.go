@@ -0,0 +1,34 @@
+package goraph
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConv2DGradients(t *testing.T) {
+	paddings := []Padding{ValidPadding(), SamePadding(), ExplicitPadding(1, 0, 2, 1)}
+	for _, padding := range paddings {
+		for _, sd := range [][2]int{{1, 1}, {2, 1}, {1, 2}, {2, 2}} {
+			t.Run(fmt.Sprintf("mode %d stride %d dilation %d", padding.Mode, sd[0], sd[1]), func(t *testing.T) {
+				r := testRand()
+				x := randomVariable(r, 2*2, 5*6)
+				kernel := randomVariable(r, 3, 2*3*2)
+				bias := randomVariable(r, 3, 1)
+				conv := Conv2D(x, kernel, bias, []int{2, 5, 6}, []int{3, 2, 3, 2}, sd[0], sd[1], padding)
+				shape := conv.OutShape()
+				checkGradients(t, project(r, conv, 2*shape[0], shape[1]*shape[2]), x, kernel, bias)
+			})
+		}
+	}
+}
+
+func TestConv2DSingleChannel(t *testing.T) {
+	x := NewVariable(1, 4, []float64{1, 2, 3, 4})
+	got := Conv2D(x, NewConstVariable(1, 4, 1), nil, []int{1, 2, 2}, []int{1, 1, 2, 2}, 1, 1, SamePadding()).Forward()
+	want := []float64{10, 6, 7, 4}
+	for i := range want {
+		if got.Data[i] != want[i] {
+			t.Fatalf("got %v, want %v", got.Data, want)
+		}
+	}
+}
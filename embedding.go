@@ -0,0 +1,131 @@
+package goraph
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+/*
+EmbeddingNode defines a node that looks up rows of Table, one for every
+element of Indices, which is a row or column vector holding integer indices
+as float64, such as a categorical feature or the tokens of a sequence. Its
+value has one row per index, so a sequence of tokens becomes a matrix with
+one position per row.
+
+The gradient of Table is delivered only to the rows that were looked up,
+with BackwardRows, so the optimizers that support it update just those rows,
+and a large table costs no more per step than the rows in use.
+
+PaddingIdx, unless negative, is an index that looks up a row of zeros and
+receives no gradient. If MaxNorm is positive, looked-up rows of Table whose
+L2 norm exceeds it are rescaled in place to MaxNorm before they are used.
+Like the optimizer updates, the rescaling writes to Table.Value.Data itself,
+so it also changes a slice the table was created from.
+*/
+type EmbeddingNode struct {
+	Table      *VariableNode
+	Indices    Node
+	PaddingIdx int
+	MaxNorm    float64
+	Value      *Matrix
+	Name       string
+	rows       []int
+	valueMutex sync.Mutex
+}
+
+func Embedding(table *VariableNode, indices Node) *EmbeddingNode {
+	return &EmbeddingNode{
+		Table:      table,
+		Indices:    indices,
+		PaddingIdx: -1,
+	}
+}
+
+/*
+NewEmbeddingTable creates a table of vocabularySize rows of the given size,
+drawn from the standard normal distribution.
+*/
+func NewEmbeddingTable(vocabularySize, size int, r *rand.Rand) *VariableNode {
+	return NewRandomVariable(vocabularySize, size, NewNormalInitWithRand(0, 1, r))
+}
+
+func (m *EmbeddingNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		indices := m.Indices.Forward()
+		if indices.Rows != 1 && indices.Cols != 1 {
+			panic("Embedding indices must be a vector")
+		}
+		table := m.Table.Forward()
+		m.rows = make([]int, len(indices.Data))
+		data := make([]float64, len(indices.Data)*table.Cols)
+		m.Table.valueMutex.Lock()
+		for i, v := range indices.Data {
+			idx := int(v)
+			if float64(idx) != v || idx < 0 || idx >= table.Rows {
+				m.Table.valueMutex.Unlock()
+				panic("Embedding index out of range")
+			}
+			m.rows[i] = idx
+			if idx == m.PaddingIdx {
+				continue
+			}
+			row := table.Data[idx*table.Cols : (idx+1)*table.Cols]
+			if m.MaxNorm > 0 {
+				sum := 0.0
+				for _, w := range row {
+					sum += w * w
+				}
+				if norm := math.Sqrt(sum); norm > m.MaxNorm {
+					for j := range row {
+						row[j] *= m.MaxNorm / norm
+					}
+				}
+			}
+			copy(data[i*table.Cols:], row)
+		}
+		m.Table.valueMutex.Unlock()
+		m.Value = NewMatrix(len(indices.Data), table.Cols, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *EmbeddingNode) Inputs() []Node {
+	return []Node{m.Table, m.Indices}
+}
+
+/*
+Gradients returns no gradients: the indices are not differentiable, and the
+gradient of Table is returned by RowGradients.
+*/
+func (m *EmbeddingNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{nil, nil}
+}
+func (m *EmbeddingNode) RowGradients(grad *Matrix) (*VariableNode, []int, *Matrix) {
+	var rows []int
+	var data []float64
+	for i, idx := range m.rows {
+		if idx != m.PaddingIdx {
+			rows = append(rows, idx)
+			data = append(data, grad.Data[i*grad.Cols:(i+1)*grad.Cols]...)
+		}
+	}
+	return m.Table, rows, NewMatrix(len(rows), grad.Cols, data)
+}
+func (m *EmbeddingNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *EmbeddingNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.Table.Reset()
+		m.Indices.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *EmbeddingNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
@@ -0,0 +1,56 @@
+package goraph
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEmbeddingGradients(t *testing.T) {
+	r := testRand()
+	table := randomVariable(r, 6, 3)
+	indices := NewVariable(4, 1, []float64{2, 0, 2, 5})
+	e := Embedding(table, indices)
+	e.PaddingIdx = 5
+	checkGradients(t, project(r, e, 4, 3), table)
+	e.Forward()
+	e.Backward(NewConstMatrix(4, 3, 1))
+	if rows, ok := table.GradientRows(); !ok || !slices.Equal(rows, []int{0, 2}) {
+		t.Fatalf("rows with a gradient %v %v, want [0 2] true", rows, ok)
+	}
+}
+
+/*
+TestEmbeddingLazyUpdate checks that accumulated and clipped gradients still
+update only the rows that were looked up: with momentum, a dense update would
+keep moving the row of the first step in the second one.
+*/
+func TestEmbeddingLazyUpdate(t *testing.T) {
+	for _, clipNorm := range []float64{0, 1e-3} {
+		table := NewConstVariable(4, 3, 0.5)
+		nn := NewBatchNeuralNetwork(func(n int) (*VariableNode, *VariableNode, Node, Node) {
+			indices := NewConstVariable(n, 1, 0)
+			target := NewConstVariable(n, 3, 0)
+			output := Embedding(table, indices)
+			return indices, target, output, MSELoss(output, target)
+		}, NewSGDOptimizer([]*VariableNode{table}, 0.5, 0.9))
+		nn.AccumulationSteps = 2
+		nn.ClipNorm = clipNorm
+		targets := [][]float64{{1, 1, 1}}
+		nn.TrainBatch([][]float64{{1}}, targets)
+		nn.TrainBatch([][]float64{{1}}, targets)
+		row1 := slices.Clone(table.Value.Data[3:6])
+		if slices.Equal(row1, []float64{0.5, 0.5, 0.5}) {
+			t.Fatal("the looked-up row was not updated")
+		}
+		nn.TrainBatch([][]float64{{2}}, targets)
+		nn.TrainBatch([][]float64{{2}}, targets)
+		if !slices.Equal(table.Value.Data[3:6], row1) {
+			t.Fatalf("clip norm %v: row 1 changed from %v to %v in a step that did not look it up", clipNorm, row1, table.Value.Data[3:6])
+		}
+		for _, r := range []int{0, 3} {
+			if !slices.Equal(table.Value.Data[r*3:(r+1)*3], []float64{0.5, 0.5, 0.5}) {
+				t.Fatalf("clip norm %v: row %d that was never looked up changed", clipNorm, r)
+			}
+		}
+	}
+}
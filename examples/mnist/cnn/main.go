@@ -20,11 +20,7 @@ func main() {
 		input = goraph.NewConstVariable(28, 28, 0)
 		target = goraph.NewConstVariable(1, 10, 0)
 		output = goraph.Conv(input, kernel, 1)
-		cbb1 := goraph.NewConstVariable(28, 1, 1)
-		cbb2 := goraph.NewConstVariable(1, 28, 1)
-		cbb := goraph.Multi(cbb1, bk)
-		cbb = goraph.Multi(cbb, cbb2)
-		output = goraph.Add(output, cbb)
+		output = goraph.Add(output, bk)
 		output = goraph.Tanh(output)
 		output = goraph.Pool(output, 2, 2, 2)
 		output = goraph.Reshape(output, 1, 14*14)
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/zenoda/goraph"
 	"math/rand/v2"
 	"mnist/dataset"
+	"os"
 )
 
 func NewRandFunc(num int) func() float64 {
@@ -20,9 +22,9 @@ func main() {
 	parameters := []*goraph.VariableNode{w1, b1, w2, b2}
 	optimizer := goraph.NewSGDOptimizer(parameters, 0.01, 0.9)
 
-	builder := func() (input, target *goraph.VariableNode, output, loss goraph.Node) {
-		input = goraph.NewConstVariable(1, 784, 0)
-		target = goraph.NewConstVariable(1, 10, 0)
+	builder := func(batchSize int) (input, target *goraph.VariableNode, output, loss goraph.Node) {
+		input = goraph.NewConstVariable(batchSize, 784, 0)
+		target = goraph.NewConstVariable(batchSize, 10, 0)
 
 		output = goraph.Multi(input, w1)
 		output = goraph.Add(output, b1)
@@ -36,17 +38,25 @@ func main() {
 		return
 	}
 
-	nn := goraph.NewNeuralNetwork(builder, optimizer)
+	nn := goraph.NewBatchNeuralNetwork(builder, optimizer)
 	model := goraph.NewModel(parameters, nil, nil)
 	model.Load("model.json")
 	{
 		inputData, targetData := dataset.ReadSamples("train")
-		for epoch := range 10 {
-			lossValue := nn.Train(inputData, targetData, 30)
-			fmt.Printf("Epoch: %d, loss: %f\n", epoch, lossValue)
+		// Hold out the last tenth of the training samples for validation, so
+		// that the checkpoint and early stopping never see the test set.
+		split := len(inputData) - len(inputData)/10
+		trainer := goraph.NewTrainer(nn, 10, 30)
+		trainer.ValidationInputs, trainer.ValidationTargets = inputData[split:], targetData[split:]
+		trainer.Metrics = []goraph.Metric{goraph.Accuracy{}}
+		trainer.Callbacks = []goraph.Callback{goraph.NewProgressCallback(os.Stdout)}
+		trainer.Checkpoint = goraph.NewModelCheckpoint(model, "model.json", "val_loss", false)
+		trainer.EarlyStopping = goraph.NewEarlyStopping("val_loss", false, 3, 0)
+		if _, err := trainer.Fit(context.Background(), inputData[:split], targetData[:split]); err != nil {
+			panic(err)
 		}
 	}
-	model.Save("model.json")
+	model.Load("model.json")
 	{
 		inputData, targetData := dataset.ReadSamples("test")
 		lossValue, outputData := nn.Evaluate(inputData, targetData)
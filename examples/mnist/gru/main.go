@@ -89,15 +89,7 @@ func main() {
 			input.Value = testInputs[i]
 			target.Value = testTargets[i]
 			result := output.Forward()
-			maxIdx := 0
-			max := 0.0
-			for j, v := range result.Data {
-				if v > max {
-					max = v
-					maxIdx = j
-				}
-			}
-			if testTargets[i].Data[maxIdx] == 1.0 {
+			if testTargets[i].Data[result.ArgMax(goraph.RowAxis)[0]] == 1.0 {
 				rate += 1.0
 			}
 			lossValue = lossValue.Add(loss.Forward())
@@ -0,0 +1,80 @@
+package goraph
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+testRand returns a fixed random source, so that the gradient checks are
+reproducible.
+*/
+func testRand() *rand.Rand {
+	return rand.New(rand.NewPCG(1, 2))
+}
+
+/*
+randomVariable creates a variable of the given shape with elements drawn
+uniformly from [-1, 1).
+*/
+func randomVariable(r *rand.Rand, rows, cols int) *VariableNode {
+	return NewRandomVariable(rows, cols, func() float64 {
+		return r.Float64()*2 - 1
+	})
+}
+
+/*
+project reduces n to a scalar through a fixed random weighting of its
+elements, so that every element of n contributes to the gradient differently.
+*/
+func project(r *rand.Rand, n Node, rows, cols int) Node {
+	w := randomVariable(r, rows, cols)
+	w.Freeze()
+	return ColSum(RowSum(MultiElement(n, w)))
+}
+
+/*
+checkGradients compares the gradients that loss, a 1x1 node, delivers to
+params with central finite differences.
+*/
+func checkGradients(t *testing.T, loss Node, params ...*VariableNode) {
+	t.Helper()
+	checkGradientsSeed(t, loss, NewConstMatrix(1, 1, 1), params...)
+}
+
+/*
+checkGradientsSeed is checkGradients for a loss that is seeded with the given
+gradient, such as nil for the loss nodes.
+*/
+func checkGradientsSeed(t *testing.T, loss Node, seed *Matrix, params ...*VariableNode) {
+	t.Helper()
+	const h, tolerance = 1e-5, 1e-5
+	loss.Reset()
+	for _, p := range params {
+		p.Reset()
+	}
+	loss.Forward()
+	loss.Backward(seed)
+	analytic := make([][]float64, len(params))
+	for i, p := range params {
+		analytic[i] = append([]float64(nil), p.Gradient.Data...)
+	}
+	for i, p := range params {
+		for j, orig := range p.Value.Data {
+			p.Value.Data[j] = orig + h
+			loss.Reset()
+			plus := loss.Forward().Data[0]
+			p.Value.Data[j] = orig - h
+			loss.Reset()
+			minus := loss.Forward().Data[0]
+			p.Value.Data[j] = orig
+			numeric := (plus - minus) / (2 * h)
+			err := math.Abs(numeric-analytic[i][j]) / max(1, math.Abs(numeric)+math.Abs(analytic[i][j]))
+			if err > tolerance {
+				t.Errorf("parameter %d element %d: analytic gradient %g, numeric %g", i, j, analytic[i][j], numeric)
+			}
+		}
+	}
+	loss.Reset()
+}
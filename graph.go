@@ -0,0 +1,198 @@
+package goraph
+
+/*
+backward performs back propagation from the root node. The graph reachable
+from the root is sorted topologically, so that every node receives the sum of
+the gradients from all of its consumers before its own Gradients is called,
+and each node is visited exactly once no matter how many consumers it has.
+Nodes that do not implement OperatorNode, such as VariableNode, receive their
+accumulated gradient through their Backward method.
+
+A nil gradient, as passed to the loss at the root, stands for a gradient of
+ones, so an expression combining losses, such as a loss plus a regularizer,
+can be the root as well.
+
+Gradients are only computed for nodes that lead to a leaf which is not a
+frozen VariableNode, so no work is spent on a frozen part of the graph whose
+inputs are frozen too.
+*/
+func backward(root Node, grad *Matrix) {
+	propagate(root, grad, leafGradient.apply)
+}
+
+/*
+leafGradient is the gradient that a backward pass delivers to a leaf node. If
+rows is not nil, the leaf is a VariableNode and grad holds the gradients of
+just those rows, as computed by a RowGradientNode.
+*/
+type leafGradient struct {
+	leaf Node
+	grad *Matrix
+	rows []int
+}
+
+func (g leafGradient) apply() {
+	if g.rows != nil {
+		g.leaf.(*VariableNode).BackwardRows(g.rows, g.grad)
+	} else {
+		g.leaf.Backward(g.grad)
+	}
+}
+
+/*
+RowGradientNode is implemented by operators whose gradient with respect to a
+VariableNode input is zero in all but a few of its rows, such as
+EmbeddingNode. Their Gradients returns nil for that input, and RowGradients
+returns the variable, the rows and their gradients instead, which are added
+to the variable with BackwardRows so that its other rows are not touched.
+*/
+type RowGradientNode interface {
+	OperatorNode
+	RowGradients(grad *Matrix) (variable *VariableNode, rows []int, rowGrads *Matrix)
+}
+
+/*
+leafGradients performs back propagation from the root node like backward, but
+returns the gradients of the leaves, in a fixed order, instead of passing them
+to the leaves. Applying them later in a fixed order makes the sums of
+gradients from concurrent backward passes reproducible.
+*/
+func leafGradients(root Node, grad *Matrix) []leafGradient {
+	var result []leafGradient
+	propagate(root, grad, func(g leafGradient) {
+		result = append(result, g)
+	})
+	return result
+}
+
+/*
+propagate computes the gradients of the graph and passes those of the nodes
+that do not implement OperatorNode, and the row gradients of RowGradientNodes,
+to deliver, in reverse topological order.
+*/
+func propagate(root Node, grad *Matrix, deliver func(g leafGradient)) {
+	if grad == nil {
+		value := root.Forward()
+		grad = NewConstMatrix(value.Rows, value.Cols, 1)
+	}
+	order := topologicalOrder(root)
+	needed := neededGradients(order)
+	grads := map[Node]*Matrix{root: grad}
+	for i := len(order) - 1; i >= 0; i-- {
+		node := order[i]
+		nodeGrad, ok := grads[node]
+		if !ok {
+			continue
+		}
+		delete(grads, node)
+		if !needed[node] {
+			continue
+		}
+		op, ok := node.(OperatorNode)
+		if !ok {
+			deliver(leafGradient{leaf: node, grad: nodeGrad})
+			continue
+		}
+		if rg, ok := op.(RowGradientNode); ok {
+			if variable, rows, rowGrads := rg.RowGradients(nodeGrad); needed[variable] && len(rows) > 0 {
+				deliver(leafGradient{leaf: variable, grad: rowGrads, rows: rows})
+			}
+		}
+		inputs := op.Inputs()
+		inputGrads := op.Gradients(nodeGrad)
+		for j, input := range inputs {
+			if inputGrads[j] == nil || !needed[input] {
+				continue
+			}
+			if acc, ok := grads[input]; ok {
+				grads[input] = acc.Add(inputGrads[j])
+			} else {
+				grads[input] = inputGrads[j]
+			}
+		}
+	}
+}
+
+/*
+topologicalOrder returns the nodes reachable from the root in post order, so
+every node appears after all of its inputs.
+*/
+func topologicalOrder(root Node) []Node {
+	type frame struct {
+		node   Node
+		inputs []Node
+		next   int
+	}
+	var order []Node
+	visited := map[Node]bool{root: true}
+	stack := []*frame{{node: root, inputs: nodeInputs(root)}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.next < len(top.inputs) {
+			input := top.inputs[top.next]
+			top.next++
+			if !visited[input] {
+				visited[input] = true
+				stack = append(stack, &frame{node: input, inputs: nodeInputs(input)})
+			}
+			continue
+		}
+		order = append(order, top.node)
+		stack = stack[:len(stack)-1]
+	}
+	return order
+}
+
+/*
+neededGradients reports for each node of a topological order whether it needs
+a gradient: a leaf unless it is a frozen VariableNode, and an operator if any
+of its inputs needs one.
+*/
+func neededGradients(order []Node) map[Node]bool {
+	needed := make(map[Node]bool, len(order))
+	for _, node := range order {
+		op, ok := node.(OperatorNode)
+		if !ok {
+			v, isVariable := node.(*VariableNode)
+			needed[node] = !isVariable || !v.Frozen
+			continue
+		}
+		for _, input := range op.Inputs() {
+			if needed[input] {
+				needed[node] = true
+				break
+			}
+		}
+	}
+	return needed
+}
+
+/*
+ModeNode is implemented by nodes that behave differently during training and
+inference, such as DropoutNode, or that update state while training, such as
+the running statistics of batch normalization. Nodes start in training mode.
+*/
+type ModeNode interface {
+	Node
+	SetTraining(training bool)
+}
+
+/*
+SetTraining switches every ModeNode reachable from the root to training or to
+inference mode. Values computed in the other mode are not cleared, so the
+graph should be reset before the next forward pass.
+*/
+func SetTraining(root Node, training bool) {
+	for _, node := range topologicalOrder(root) {
+		if m, ok := node.(ModeNode); ok {
+			m.SetTraining(training)
+		}
+	}
+}
+
+func nodeInputs(node Node) []Node {
+	if op, ok := node.(OperatorNode); ok {
+		return op.Inputs()
+	}
+	return nil
+}
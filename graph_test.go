@@ -0,0 +1,121 @@
+package goraph
+
+import (
+	"sync"
+	"testing"
+)
+
+/*
+countingNode passes X through unchanged and counts how often its Gradients
+is called.
+*/
+type countingNode struct {
+	X          Node
+	Calls      int
+	Value      *Matrix
+	valueMutex sync.Mutex
+}
+
+func (m *countingNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = m.X.Forward()
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *countingNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *countingNode) Gradients(grad *Matrix) []*Matrix {
+	m.Calls++
+	return []*Matrix{grad}
+}
+func (m *countingNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *countingNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *countingNode) Tag(name string) Node {
+	return m
+}
+
+func TestBackwardSharedNode(t *testing.T) {
+	x := NewVariable(1, 3, []float64{1, -2, 0.5})
+	shared := &countingNode{X: x}
+	// x*x + x has the gradient 2x+1, which needs the gradients of both
+	// consumers of the shared node to be summed.
+	loss := Add(MultiElement(shared, shared), shared)
+	loss.Forward()
+	loss.Backward(NewConstMatrix(1, 3, 1))
+	if shared.Calls != 1 {
+		t.Fatalf("shared node visited %d times, want once", shared.Calls)
+	}
+	for i, v := range x.Value.Data {
+		if want := 2*v + 1; x.Gradient.Data[i] != want {
+			t.Fatalf("gradient %v, want 2x+1", x.Gradient.Data)
+		}
+	}
+	r := testRand()
+	checkGradients(t, project(r, loss, 1, 3), x)
+}
+
+func TestBackwardPrunesFrozenBranch(t *testing.T) {
+	frozen := NewVariable(1, 2, []float64{1, 2})
+	frozen.Freeze()
+	w := NewVariable(1, 2, []float64{3, 4})
+	branch := &countingNode{X: Sigmoid(frozen)}
+	loss := Add(MultiElement(branch, w), w)
+	loss.Forward()
+	loss.Backward(NewConstMatrix(1, 2, 1))
+	if branch.Calls != 0 {
+		t.Fatalf("frozen branch visited %d times, want none", branch.Calls)
+	}
+	if frozen.Gradient.Data[0] != 0 || frozen.Gradient.Data[1] != 0 {
+		t.Fatalf("frozen variable received the gradient %v", frozen.Gradient.Data)
+	}
+	want := branch.Forward()
+	for i := range w.Gradient.Data {
+		if w.Gradient.Data[i] != want.Data[i]+1 {
+			t.Fatalf("gradient of w %v, want sigmoid(frozen)+1", w.Gradient.Data)
+		}
+	}
+}
+
+func TestTopologicalOrderVisitsOnce(t *testing.T) {
+	x := NewVariable(1, 1, []float64{2})
+	s := Sigmoid(x)
+	loss := Add(MultiElement(s, s), s)
+	order := topologicalOrder(loss)
+	seen := map[Node]int{}
+	for _, node := range order {
+		seen[node]++
+	}
+	if seen[x] != 1 || seen[s] != 1 {
+		t.Fatalf("shared nodes appear %d and %d times, want once", seen[x], seen[s])
+	}
+	if order[len(order)-1] != Node(loss) {
+		t.Fatalf("root is not last in the order")
+	}
+}
+
+func TestNeededGradientsFrozen(t *testing.T) {
+	frozen := NewVariable(1, 1, []float64{1})
+	frozen.Freeze()
+	w := NewVariable(1, 1, []float64{2})
+	branch := Sigmoid(frozen)
+	loss := Add(branch, w)
+	needed := neededGradients(topologicalOrder(loss))
+	if needed[frozen] || needed[branch] {
+		t.Fatalf("frozen branch marked as needing a gradient")
+	}
+	if !needed[w] || !needed[loss] {
+		t.Fatalf("trainable path not marked as needing a gradient")
+	}
+}
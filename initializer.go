@@ -5,20 +5,238 @@ import (
 	"math/rand/v2"
 )
 
-func NewXavierNormalInit(fanIn, fanOut int) func() float64 {
+/*
+Initializers return functions that produce the initial values of a parameter
+one element at a time, to be passed to NewRandomVariable or NewRandomMatrix.
+The random initializers draw from the library random source, which is seeded
+with SetSeed. Each also has a WithRand variant that takes the random source to
+draw from, so a seeded *rand.Rand gives the same weights in every run; a nil
+source selects the library random source.
+*/
+
+func uniformSource(r *rand.Rand) func() float64 {
+	if r == nil {
+		return libraryRand.Float64
+	}
+	return r.Float64
+}
+
+func normalSource(r *rand.Rand) func() float64 {
+	if r == nil {
+		return libraryRand.NormFloat64
+	}
+	return r.NormFloat64
+}
+
+/*
+NewUniformInit draws from the uniform distribution over [low, high).
+*/
+func NewUniformInit(low, high float64) func() float64 {
+	return NewUniformInitWithRand(low, high, nil)
+}
+
+func NewUniformInitWithRand(low, high float64, r *rand.Rand) func() float64 {
+	uniform := uniformSource(r)
 	return func() float64 {
-		return (rand.Float64()*2 - 1) * math.Sqrt(2.0/float64(fanIn+fanOut))
+		return low + (high-low)*uniform()
 	}
 }
 
-func NewXavierUniformInit(fanIn, fanOut int) func() float64 {
+/*
+NewNormalInit draws from the normal distribution with the given mean and
+standard deviation.
+*/
+func NewNormalInit(mean, std float64) func() float64 {
+	return NewNormalInitWithRand(mean, std, nil)
+}
+
+func NewNormalInitWithRand(mean, std float64, r *rand.Rand) func() float64 {
+	normal := normalSource(r)
+	return func() float64 {
+		return mean + std*normal()
+	}
+}
+
+/*
+NewTruncatedNormalInit draws from the normal distribution with the given mean
+and standard deviation, redrawing values more than two standard deviations
+away from the mean.
+*/
+func NewTruncatedNormalInit(mean, std float64) func() float64 {
+	return NewTruncatedNormalInitWithRand(mean, std, nil)
+}
+
+func NewTruncatedNormalInitWithRand(mean, std float64, r *rand.Rand) func() float64 {
+	normal := normalSource(r)
 	return func() float64 {
-		return (rand.Float64()*2 - 1) * math.Sqrt(6.0/float64(fanIn+fanOut))
+		for {
+			if v := normal(); math.Abs(v) <= 2 {
+				return mean + std*v
+			}
+		}
 	}
 }
 
+func NewXavierNormalInit(fanIn, fanOut int) func() float64 {
+	return NewXavierNormalInitWithRand(fanIn, fanOut, nil)
+}
+
+func NewXavierNormalInitWithRand(fanIn, fanOut int, r *rand.Rand) func() float64 {
+	return NewNormalInitWithRand(0, math.Sqrt(2.0/float64(fanIn+fanOut)), r)
+}
+
+func NewXavierUniformInit(fanIn, fanOut int) func() float64 {
+	return NewXavierUniformInitWithRand(fanIn, fanOut, nil)
+}
+
+func NewXavierUniformInitWithRand(fanIn, fanOut int, r *rand.Rand) func() float64 {
+	limit := math.Sqrt(6.0 / float64(fanIn+fanOut))
+	return NewUniformInitWithRand(-limit, limit, r)
+}
+
 func NewKaimingNormalInit(fanIn int) func() float64 {
+	return NewKaimingNormalInitWithRand(fanIn, nil)
+}
+
+func NewKaimingNormalInitWithRand(fanIn int, r *rand.Rand) func() float64 {
+	return NewNormalInitWithRand(0, math.Sqrt(2.0/float64(fanIn)), r)
+}
+
+func NewKaimingUniformInit(fanIn int) func() float64 {
+	return NewKaimingUniformInitWithRand(fanIn, nil)
+}
+
+func NewKaimingUniformInitWithRand(fanIn int, r *rand.Rand) func() float64 {
+	limit := math.Sqrt(6.0 / float64(fanIn))
+	return NewUniformInitWithRand(-limit, limit, r)
+}
+
+func NewLeCunNormalInit(fanIn int) func() float64 {
+	return NewLeCunNormalInitWithRand(fanIn, nil)
+}
+
+func NewLeCunNormalInitWithRand(fanIn int, r *rand.Rand) func() float64 {
+	return NewNormalInitWithRand(0, math.Sqrt(1.0/float64(fanIn)), r)
+}
+
+func NewLeCunUniformInit(fanIn int) func() float64 {
+	return NewLeCunUniformInitWithRand(fanIn, nil)
+}
+
+func NewLeCunUniformInitWithRand(fanIn int, r *rand.Rand) func() float64 {
+	limit := math.Sqrt(3.0 / float64(fanIn))
+	return NewUniformInitWithRand(-limit, limit, r)
+}
+
+func NewConstInit(value float64) func() float64 {
+	return func() float64 {
+		return value
+	}
+}
+
+func NewZerosInit() func() float64 {
+	return NewConstInit(0)
+}
+
+func NewOnesInit() func() float64 {
+	return NewConstInit(1)
+}
+
+/*
+NewIdentityInit produces the elements of a rows x cols matrix, in row-major
+order, with gain on the diagonal and zeros elsewhere. Unlike the random
+initializers, it must be used for a matrix of exactly that shape; further
+calls start over with the first element.
+*/
+func NewIdentityInit(rows, cols int, gain float64) func() float64 {
+	idx := 0
+	return func() float64 {
+		i, j := idx/cols, idx%cols
+		idx = (idx + 1) % (rows * cols)
+		if i == j {
+			return gain
+		}
+		return 0
+	}
+}
+
+/*
+NewOrthogonalInit produces the elements of a random rows x cols matrix, in
+row-major order, whose rows, or columns if there are fewer columns than rows,
+are orthonormal and then scaled by gain. The matrix is obtained by
+orthogonalizing a matrix drawn from the standard normal distribution. Like
+NewIdentityInit it must be used for a matrix of exactly that shape; further
+calls continue with a new matrix.
+*/
+func NewOrthogonalInit(rows, cols int, gain float64) func() float64 {
+	return NewOrthogonalInitWithRand(rows, cols, gain, nil)
+}
+
+func NewOrthogonalInitWithRand(rows, cols int, gain float64, r *rand.Rand) func() float64 {
+	normal := normalSource(r)
+	var data []float64
+	idx := 0
 	return func() float64 {
-		return (rand.Float64()*2 - 1) * math.Sqrt(2.0/float64(fanIn))
+		if idx == 0 {
+			data = orthogonalMatrix(rows, cols, normal)
+		}
+		v := gain * data[idx]
+		idx = (idx + 1) % (rows * cols)
+		return v
+	}
+}
+
+/*
+orthogonalMatrix orthonormalizes the shorter dimension of a normal random
+matrix with the modified Gram-Schmidt process, redrawing vectors that turn out
+to be linearly dependent.
+*/
+func orthogonalMatrix(rows, cols int, normal func() float64) []float64 {
+	// Work on vectors of length n, count of them m <= n, then lay them out
+	// as rows or columns.
+	m, n := rows, cols
+	if rows > cols {
+		m, n = cols, rows
+	}
+	vectors := make([][]float64, m)
+	for i := range vectors {
+		for {
+			v := make([]float64, n)
+			for k := range v {
+				v[k] = normal()
+			}
+			for _, u := range vectors[:i] {
+				dot := 0.0
+				for k := range v {
+					dot += v[k] * u[k]
+				}
+				for k := range v {
+					v[k] -= dot * u[k]
+				}
+			}
+			norm := 0.0
+			for _, x := range v {
+				norm += x * x
+			}
+			norm = math.Sqrt(norm)
+			if norm > 1e-10 {
+				for k := range v {
+					v[k] /= norm
+				}
+				vectors[i] = v
+				break
+			}
+		}
+	}
+	data := make([]float64, rows*cols)
+	for i, v := range vectors {
+		for k, x := range v {
+			if rows <= cols {
+				data[i*cols+k] = x
+			} else {
+				data[k*cols+i] = x
+			}
+		}
 	}
+	return data
 }
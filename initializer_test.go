@@ -0,0 +1,68 @@
+package goraph
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+/*
+draw returns n values of an initializer.
+*/
+func draw(init func() float64, n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = init()
+	}
+	return values
+}
+
+func TestInitializersWithRandDeterministic(t *testing.T) {
+	initializers := map[string]func(r *rand.Rand) func() float64{
+		"Uniform":         func(r *rand.Rand) func() float64 { return NewUniformInitWithRand(-1, 1, r) },
+		"Normal":          func(r *rand.Rand) func() float64 { return NewNormalInitWithRand(0, 1, r) },
+		"TruncatedNormal": func(r *rand.Rand) func() float64 { return NewTruncatedNormalInitWithRand(0, 1, r) },
+		"XavierNormal":    func(r *rand.Rand) func() float64 { return NewXavierNormalInitWithRand(4, 6, r) },
+		"XavierUniform":   func(r *rand.Rand) func() float64 { return NewXavierUniformInitWithRand(4, 6, r) },
+		"KaimingNormal":   func(r *rand.Rand) func() float64 { return NewKaimingNormalInitWithRand(4, r) },
+		"KaimingUniform":  func(r *rand.Rand) func() float64 { return NewKaimingUniformInitWithRand(4, r) },
+		"LeCunNormal":     func(r *rand.Rand) func() float64 { return NewLeCunNormalInitWithRand(4, r) },
+		"LeCunUniform":    func(r *rand.Rand) func() float64 { return NewLeCunUniformInitWithRand(4, r) },
+		"Orthogonal":      func(r *rand.Rand) func() float64 { return NewOrthogonalInitWithRand(3, 4, 1, r) },
+	}
+	for name, init := range initializers {
+		first := draw(init(rand.New(rand.NewPCG(7, 8))), 24)
+		second := draw(init(rand.New(rand.NewPCG(7, 8))), 24)
+		if !slices.Equal(first, second) {
+			t.Errorf("%s: the same seed gave different weights", name)
+		}
+		other := draw(init(rand.New(rand.NewPCG(9, 10))), 24)
+		if slices.Equal(first, other) {
+			t.Errorf("%s: different seeds gave the same weights", name)
+		}
+	}
+}
+
+func TestUniformInitializerBounds(t *testing.T) {
+	r := testRand()
+	for _, c := range []struct {
+		name  string
+		init  func() float64
+		limit float64
+	}{
+		{"XavierUniform", NewXavierUniformInitWithRand(4, 8, r), math.Sqrt(6.0 / 12)},
+		{"KaimingUniform", NewKaimingUniformInitWithRand(6, r), 1},
+		{"LeCunUniform", NewLeCunUniformInitWithRand(12, r), 0.5},
+	} {
+		values := draw(c.init, 10000)
+		low, high := slices.Min(values), slices.Max(values)
+		if low < -c.limit || high >= c.limit {
+			t.Errorf("%s: values in [%g, %g], want within ±%g", c.name, low, high, c.limit)
+		}
+		// The values fill the range: the extremes are close to the limits.
+		if low > -0.99*c.limit || high < 0.99*c.limit {
+			t.Errorf("%s: values in [%g, %g] do not reach ±%g", c.name, low, high, c.limit)
+		}
+	}
+}
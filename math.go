@@ -0,0 +1,743 @@
+package goraph
+
+import (
+	"math"
+	"sync"
+)
+
+/*
+The element-wise math nodes follow one policy for elements outside the domain
+of their function: where the function or its derivative is undefined, which
+would give NaN, such as for a negative number raised to a fractional power,
+or where it is not differentiable, the value or gradient is replaced by 0 or
+by the subgradient documented for the function. Results that are defined but
+too large for a float64 overflow to ±Inf, as they do in the math package, and
+are left alone, so that an overflow shows up instead of silently turning into
+0.
+*/
+
+/*
+definedOrZero returns v, or 0 if v is NaN, the result of an undefined
+operation.
+*/
+func definedOrZero(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+/*
+pow returns x^p, or 0 if it is undefined.
+*/
+func pow(x, p float64) float64 {
+	return definedOrZero(math.Pow(x, p))
+}
+
+/*
+powGradient returns the derivative p*x^(p-1) of x^p with respect to x, or 0
+where it is undefined: at 0 with p < 1, where x^p is not differentiable, and
+wherever x^p itself is undefined.
+*/
+func powGradient(x, p float64) float64 {
+	if x == 0 && p < 1 {
+		return 0
+	}
+	return definedOrZero(p * math.Pow(x, p-1))
+}
+
+/*
+ExpNode defines a node that computes e^x for every element of X. It is
+defined everywhere; large elements overflow to +Inf.
+*/
+type ExpNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Exp(x Node) *ExpNode {
+	return &ExpNode{
+		X: x,
+	}
+}
+
+func (m *ExpNode) apply(x float64) float64 {
+	return math.Exp(x)
+}
+
+func (m *ExpNode) derivative(x, y float64) float64 {
+	return y
+}
+
+func (m *ExpNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *ExpNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ExpNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *ExpNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *ExpNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *ExpNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+PowNode defines a node that raises every element of X to the power P.
+Elements without a real power, such as negative elements with a non-integer
+power, give 0 with a gradient of 0, and 0 with P < 1 gets a gradient of 0. 0
+with a negative power gives +Inf, and large results overflow to ±Inf.
+*/
+type PowNode struct {
+	X          Node
+	P          float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Pow(x Node, p float64) *PowNode {
+	return &PowNode{
+		X: x,
+		P: p,
+	}
+}
+
+func (m *PowNode) apply(x float64) float64 {
+	return pow(x, m.P)
+}
+
+func (m *PowNode) derivative(x, y float64) float64 {
+	return powGradient(x, m.P)
+}
+
+func (m *PowNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *PowNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *PowNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *PowNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *PowNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *PowNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+SquareNode defines a node that squares every element of X; large elements
+overflow to +Inf.
+*/
+type SquareNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Square(x Node) *SquareNode {
+	return &SquareNode{
+		X: x,
+	}
+}
+
+func (m *SquareNode) apply(x float64) float64 {
+	return x * x
+}
+
+func (m *SquareNode) derivative(x, y float64) float64 {
+	return 2 * x
+}
+
+func (m *SquareNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SquareNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SquareNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SquareNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SquareNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SquareNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+SqrtNode defines a node that computes the square root of every element of
+X. Negative elements are treated as 0, and they and 0 itself get a gradient
+of 0.
+*/
+type SqrtNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Sqrt(x Node) *SqrtNode {
+	return &SqrtNode{
+		X: x,
+	}
+}
+
+func (m *SqrtNode) apply(x float64) float64 {
+	return math.Sqrt(max(x, 0))
+}
+
+func (m *SqrtNode) derivative(x, y float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return 0.5 / y
+}
+
+func (m *SqrtNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SqrtNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SqrtNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SqrtNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SqrtNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SqrtNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+RsqrtNode defines a node that computes 1/√(x+Eps) for every element x of X.
+Negative elements are treated as 0 and get a gradient of 0. A positive Eps
+keeps the value of 0 finite; with an Eps of 0, 0 gives +Inf with a gradient
+of 0.
+*/
+type RsqrtNode struct {
+	X          Node
+	Eps        float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Rsqrt(x Node, eps float64) *RsqrtNode {
+	return &RsqrtNode{
+		X:   x,
+		Eps: eps,
+	}
+}
+
+func (m *RsqrtNode) apply(x float64) float64 {
+	return 1 / math.Sqrt(max(x, 0)+m.Eps)
+}
+
+func (m *RsqrtNode) derivative(x, y float64) float64 {
+	if x < 0 || x+m.Eps <= 0 {
+		return 0
+	}
+	return -0.5 * y / (x + m.Eps)
+}
+
+func (m *RsqrtNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *RsqrtNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *RsqrtNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *RsqrtNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *RsqrtNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *RsqrtNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+AbsNode defines a node that computes the absolute value of every element of
+X, with a gradient of 0 at 0.
+*/
+type AbsNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Abs(x Node) *AbsNode {
+	return &AbsNode{
+		X: x,
+	}
+}
+
+func (m *AbsNode) apply(x float64) float64 {
+	return math.Abs(x)
+}
+
+func (m *AbsNode) derivative(x, y float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func (m *AbsNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *AbsNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *AbsNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *AbsNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *AbsNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *AbsNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+SinNode defines a node that computes the sine of every element of X, in
+radians; it is defined everywhere.
+*/
+type SinNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Sin(x Node) *SinNode {
+	return &SinNode{
+		X: x,
+	}
+}
+
+func (m *SinNode) apply(x float64) float64 {
+	return math.Sin(x)
+}
+
+func (m *SinNode) derivative(x, y float64) float64 {
+	return math.Cos(x)
+}
+
+func (m *SinNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SinNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SinNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *SinNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SinNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SinNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+CosNode defines a node that computes the cosine of every element of X, in
+radians; it is defined everywhere.
+*/
+type CosNode struct {
+	X          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Cos(x Node) *CosNode {
+	return &CosNode{
+		X: x,
+	}
+}
+
+func (m *CosNode) apply(x float64) float64 {
+	return math.Cos(x)
+}
+
+func (m *CosNode) derivative(x, y float64) float64 {
+	return -math.Sin(x)
+}
+
+func (m *CosNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = mapElements(m.X.Forward(), m.apply)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *CosNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *CosNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{elementGradients(grad, m.X.Forward(), m.Forward(), m.derivative)}
+}
+func (m *CosNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *CosNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *CosNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+PowElementNode defines a node that raises the elements of X to the powers in
+the corresponding elements of Y, broadcasting them against each other. As
+for Pow, elements without a real power give 0 with a gradient of 0, a 0 in X
+with a power below 1 gets a gradient of 0, and large results overflow to
+±Inf. The gradient with respect to the exponent is 0 where X is not positive,
+as log(X) is undefined there.
+*/
+type PowElementNode struct {
+	X          Node
+	Y          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func PowElement(x, y Node) *PowElementNode {
+	return &PowElementNode{
+		X: x,
+		Y: y,
+	}
+}
+
+func (m *PowElementNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = m.X.Forward().broadcast(m.Y.Forward(), func(x, y float64) float64 {
+			return pow(x, y)
+		})
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *PowElementNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *PowElementNode) Gradients(grad *Matrix) []*Matrix {
+	x, y := m.X.Forward(), m.Y.Forward()
+	gradX := x.broadcast(y, powGradient).MultiElement(grad)
+	gradY := x.broadcast(y, func(x, y float64) float64 {
+		if x <= 0 {
+			return 0
+		}
+		return definedOrZero(math.Pow(x, y) * math.Log(x))
+	}).MultiElement(grad)
+	return []*Matrix{gradX.sumTo(x.Rows, x.Cols), gradY.sumTo(y.Rows, y.Cols)}
+}
+func (m *PowElementNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *PowElementNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Y.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *PowElementNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+ExtremumNode defines a node that takes the element-wise maximum of X and Y,
+or the minimum if Min is set, broadcasting them against each other. The
+gradient goes to the operand that was selected; where both are equal it is
+split evenly between them.
+*/
+type ExtremumNode struct {
+	X          Node
+	Y          Node
+	Min        bool
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Maximum(x, y Node) *ExtremumNode {
+	return &ExtremumNode{
+		X: x,
+		Y: y,
+	}
+}
+
+func Minimum(x, y Node) *ExtremumNode {
+	return &ExtremumNode{
+		X:   x,
+		Y:   y,
+		Min: true,
+	}
+}
+
+/*
+share returns the share of the gradient that goes to x, as opposed to y.
+*/
+func (m *ExtremumNode) share(x, y float64) float64 {
+	switch {
+	case x == y:
+		return 0.5
+	case (x > y) != m.Min:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (m *ExtremumNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = m.X.Forward().broadcast(m.Y.Forward(), func(x, y float64) float64 {
+			if m.Min {
+				return min(x, y)
+			}
+			return max(x, y)
+		})
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *ExtremumNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *ExtremumNode) Gradients(grad *Matrix) []*Matrix {
+	x, y := m.X.Forward(), m.Y.Forward()
+	shareX := x.broadcast(y, m.share)
+	gradX := shareX.MultiElement(grad)
+	gradY := grad.Sub(gradX)
+	return []*Matrix{gradX.sumTo(x.Rows, x.Cols), gradY.sumTo(y.Rows, y.Cols)}
+}
+func (m *ExtremumNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *ExtremumNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Y.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *ExtremumNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+WhereNode defines a node that selects, element by element, the element of X
+where Cond is non-zero and the element of Y elsewhere, broadcasting the three
+against each other. The gradient goes to the operand that was selected;
+Cond, usually a mask, receives none.
+*/
+type WhereNode struct {
+	Cond       Node
+	X          Node
+	Y          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Where(cond, x, y Node) *WhereNode {
+	return &WhereNode{
+		Cond: cond,
+		X:    x,
+		Y:    y,
+	}
+}
+
+/*
+mask returns the condition broadcast to the shape of the result, with 1 where
+it is non-zero and 0 elsewhere.
+*/
+func (m *WhereNode) mask() *Matrix {
+	cond, x, y := m.Cond.Forward(), m.X.Forward(), m.Y.Forward()
+	shape := NewConstMatrix(broadcastDim(x.Rows, y.Rows), broadcastDim(x.Cols, y.Cols), 0)
+	return cond.broadcast(shape, func(c, _ float64) float64 {
+		if c != 0 {
+			return 1
+		}
+		return 0
+	})
+}
+
+func (m *WhereNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x, y := m.X.Forward(), m.Y.Forward()
+		mask := m.mask()
+		m.Value = NewConstMatrix(mask.Rows, mask.Cols, 0)
+		for i := range mask.Rows {
+			for j := range mask.Cols {
+				// Selecting rather than blending keeps a non-finite element of
+				// the operand that is not selected out of the value.
+				src := y
+				if mask.Data[i*mask.Cols+j] != 0 {
+					src = x
+				}
+				m.Value.Data[i*mask.Cols+j] = src.Data[(i%src.Rows)*src.Cols+j%src.Cols]
+			}
+		}
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *WhereNode) Inputs() []Node {
+	return []Node{m.Cond, m.X, m.Y}
+}
+func (m *WhereNode) Gradients(grad *Matrix) []*Matrix {
+	x, y := m.X.Forward(), m.Y.Forward()
+	gradX := m.mask().MultiElement(grad)
+	gradY := grad.Sub(gradX)
+	return []*Matrix{nil, gradX.sumTo(x.Rows, x.Cols), gradY.sumTo(y.Rows, y.Cols)}
+}
+func (m *WhereNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *WhereNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.Cond.Reset()
+		m.X.Reset()
+		m.Y.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *WhereNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
@@ -0,0 +1,92 @@
+package goraph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMathGradients(t *testing.T) {
+	functions := map[string]func(x Node) Node{
+		"Exp":    func(x Node) Node { return Exp(x) },
+		"Pow":    func(x Node) Node { return Pow(x, 3) },
+		"Square": func(x Node) Node { return Square(x) },
+		"Sqrt":   func(x Node) Node { return Sqrt(x) },
+		"Rsqrt":  func(x Node) Node { return Rsqrt(x, 1e-3) },
+		"Abs":    func(x Node) Node { return Abs(x) },
+		"Sin":    func(x Node) Node { return Sin(x) },
+		"Cos":    func(x Node) Node { return Cos(x) },
+	}
+	for name, function := range functions {
+		t.Run(name, func(t *testing.T) {
+			r := testRand()
+			x := NewRandomVariable(4, 5, func() float64 { return r.Float64()*2 + 0.1 })
+			if name == "Abs" || name == "Pow" || name == "Sin" {
+				x = randomVariable(r, 4, 5)
+			}
+			checkGradients(t, project(r, function(x), 4, 5), x)
+		})
+	}
+}
+
+func TestMathParameters(t *testing.T) {
+	x := NewConstVariable(1, 1, 2)
+	if p := Pow(x, 3).P; p != 3 {
+		t.Errorf("Pow P is %g, want 3", p)
+	}
+	if eps := Rsqrt(x, 1e-3).Eps; eps != 1e-3 {
+		t.Errorf("Rsqrt Eps is %g, want 0.001", eps)
+	}
+}
+
+func TestMathDomain(t *testing.T) {
+	cases := []struct {
+		name        string
+		node        OperatorNode
+		value, grad float64
+	}{
+		{"overflow", Pow(NewVariable(1, 1, []float64{1e200}), 2), math.Inf(1), 2e200},
+		{"fractional power of a negative number", Pow(NewVariable(1, 1, []float64{-8}), 1.0/3), 0, 0},
+		{"zero to a power below 1", Pow(NewVariable(1, 1, []float64{0}), 0.5), 0, 0},
+		{"exp overflow", Exp(NewVariable(1, 1, []float64{1000})), math.Inf(1), math.Inf(1)},
+		{"rsqrt of zero", Rsqrt(NewVariable(1, 1, []float64{0}), 0), math.Inf(1), 0},
+		{"sqrt of a negative number", Sqrt(NewVariable(1, 1, []float64{-4})), 0, 0},
+	}
+	for _, c := range cases {
+		value := c.node.Forward().Data[0]
+		grad := c.node.Gradients(NewConstMatrix(1, 1, 1))[0].Data[0]
+		if value != c.value || grad != c.grad {
+			t.Errorf("%s: value %v and gradient %v, want %v and %v", c.name, value, grad, c.value, c.grad)
+		}
+	}
+}
+
+func TestPowElementGradients(t *testing.T) {
+	r := testRand()
+	x := NewRandomVariable(3, 4, func() float64 { return r.Float64()*2 + 0.1 })
+	for _, y := range []*VariableNode{randomVariable(r, 3, 4), randomVariable(r, 1, 4), randomVariable(r, 1, 1)} {
+		checkGradients(t, project(r, PowElement(x, y), 3, 4), x, y)
+	}
+}
+
+func TestExtremumGradients(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 3, 4)
+	y := randomVariable(r, 1, 4)
+	// Make some elements tie, where the gradient is split evenly, which is
+	// also what the central difference measures.
+	x.Value.Data[1], x.Value.Data[6] = y.Value.Data[1], y.Value.Data[2]
+	checkGradients(t, project(r, Maximum(x, y), 3, 4), x, y)
+	checkGradients(t, project(r, Minimum(x, y), 3, 4), x, y)
+}
+
+func TestWhereGradients(t *testing.T) {
+	r := testRand()
+	cond := NewVariable(3, 1, []float64{1, 0, 1})
+	x, y := randomVariable(r, 3, 4), randomVariable(r, 1, 4)
+	where := Where(cond, x, y)
+	checkGradients(t, project(r, where, 3, 4), x, y)
+	x.Value.Data[4] = math.Inf(1)
+	if v := where.Forward().Data[4]; v != y.Value.Data[0] {
+		t.Fatalf("Where selected %v where the condition is 0, want %v", v, y.Value.Data[0])
+	}
+}
@@ -1,6 +1,9 @@
 package goraph
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 type Matrix struct {
 	Data []float64 `json:"data"`
@@ -52,15 +55,12 @@ func NewRandomMatrix(rows, cols int, f func() float64) *Matrix {
 	}
 }
 
+/*
+Add returns the element-wise sum of the matrices, broadcasting as described
+for broadcast.
+*/
 func (m *Matrix) Add(other *Matrix) (result *Matrix) {
-	if m.Rows != other.Rows || m.Cols != other.Cols {
-		panic("Matrix dimensions do not match")
-	}
-	data := make([]float64, m.Rows*m.Cols)
-	for i := range data {
-		data[i] = m.Data[i] + other.Data[i]
-	}
-	return NewMatrix(m.Rows, m.Cols, data)
+	return m.broadcast(other, func(a, b float64) float64 { return a + b })
 }
 
 func (m *Matrix) Multi(other *Matrix) (result *Matrix) {
@@ -79,14 +79,7 @@ func (m *Matrix) Multi(other *Matrix) (result *Matrix) {
 }
 
 func (m *Matrix) Sub(other *Matrix) (result *Matrix) {
-	if m.Rows != other.Rows || m.Cols != other.Cols {
-		panic("Matrix dimensions do not match")
-	}
-	data := make([]float64, m.Rows*m.Cols)
-	for i := range data {
-		data[i] = m.Data[i] - other.Data[i]
-	}
-	return NewMatrix(m.Rows, m.Cols, data)
+	return m.broadcast(other, func(a, b float64) float64 { return a - b })
 }
 
 func (m *Matrix) Negate() (result *Matrix) {
@@ -174,19 +167,11 @@ func (m *Matrix) ColSlice(start, end int) *Matrix {
 }
 
 func (m *Matrix) MultiElement(other *Matrix) (result *Matrix) {
-	data := make([]float64, m.Rows*m.Cols)
-	for i := range data {
-		data[i] = m.Data[i] * other.Data[i]
-	}
-	return NewMatrix(m.Rows, m.Cols, data)
+	return m.broadcast(other, func(a, b float64) float64 { return a * b })
 }
 
 func (m *Matrix) DivElement(other *Matrix) *Matrix {
-	data := make([]float64, m.Rows*m.Cols)
-	for i := range data {
-		data[i] = m.Data[i] / other.Data[i]
-	}
-	return NewMatrix(m.Rows, m.Cols, data)
+	return m.broadcast(other, func(a, b float64) float64 { return a / b })
 }
 
 func (m *Matrix) Reshape(rows, cols int) *Matrix {
@@ -213,3 +198,182 @@ func (m *Matrix) ColSum() *Matrix {
 	}
 	return NewMatrix(1, m.Cols, data)
 }
+
+/*
+Axis selects the direction of an operation on a matrix. RowAxis applies the
+operation within each row, like RowSum; ColAxis applies it within each column,
+like ColSum; AllAxis applies it to the matrix as a whole.
+*/
+type Axis int
+
+const (
+	RowAxis Axis = iota
+	ColAxis
+	AllAxis
+)
+
+/*
+lanes returns, for the given axis, the indices into Data of every row, every
+column, or of the whole matrix.
+*/
+func (m *Matrix) lanes(axis Axis) [][]int {
+	var lanes [][]int
+	switch axis {
+	case RowAxis:
+		lanes = make([][]int, m.Rows)
+		for i := range m.Rows {
+			lanes[i] = make([]int, m.Cols)
+			for j := range m.Cols {
+				lanes[i][j] = i*m.Cols + j
+			}
+		}
+	case ColAxis:
+		lanes = make([][]int, m.Cols)
+		for j := range m.Cols {
+			lanes[j] = make([]int, m.Rows)
+			for i := range m.Rows {
+				lanes[j][i] = i*m.Cols + j
+			}
+		}
+	case AllAxis:
+		lanes = make([][]int, 1)
+		lanes[0] = make([]int, len(m.Data))
+		for i := range m.Data {
+			lanes[0][i] = i
+		}
+	default:
+		panic("Invalid axis")
+	}
+	return lanes
+}
+
+func argMax(values []float64) int {
+	maxIdx := 0
+	for i, v := range values {
+		if v > values[maxIdx] {
+			maxIdx = i
+		}
+	}
+	return maxIdx
+}
+
+func argMin(values []float64) int {
+	minIdx := 0
+	for i, v := range values {
+		if v < values[minIdx] {
+			minIdx = i
+		}
+	}
+	return minIdx
+}
+
+/*
+ArgMax returns, for every lane along axis, the position of its largest value
+within the lane, the first one if several are equal: the column for RowAxis,
+the row for ColAxis, and the index into Data for AllAxis. For the output of a
+classifier with one sample per row, ArgMax(RowAxis) gives the predicted
+classes.
+*/
+func (m *Matrix) ArgMax(axis Axis) []int {
+	lanes := m.lanes(axis)
+	result := make([]int, len(lanes))
+	for l, values := range laneValues(m, lanes) {
+		result[l] = argMax(values)
+	}
+	return result
+}
+
+/*
+ArgMin returns, for every lane along axis, the position of its smallest value
+within the lane, like ArgMax.
+*/
+func (m *Matrix) ArgMin(axis Axis) []int {
+	lanes := m.lanes(axis)
+	result := make([]int, len(lanes))
+	for l, values := range laneValues(m, lanes) {
+		result[l] = argMin(values)
+	}
+	return result
+}
+
+/*
+TopK returns, for every lane along axis, the positions of its k largest
+values within the lane, like ArgMax, from the largest down. Equal values keep
+the order of their positions. If a lane has fewer than k values, all of them
+are returned. TopK panics if k is negative.
+*/
+func (m *Matrix) TopK(k int, axis Axis) [][]int {
+	if k < 0 {
+		panic("k must not be negative")
+	}
+	lanes := m.lanes(axis)
+	result := make([][]int, len(lanes))
+	for l, values := range laneValues(m, lanes) {
+		positions := make([]int, len(values))
+		for i := range positions {
+			positions[i] = i
+		}
+		sort.SliceStable(positions, func(i, j int) bool {
+			return values[positions[i]] > values[positions[j]]
+		})
+		result[l] = positions[:min(k, len(positions))]
+	}
+	return result
+}
+
+/*
+broadcast applies f to the corresponding elements of the matrices following
+NumPy broadcasting rules: along each dimension the sizes must either be equal,
+or one of them must be 1, in which case that row or column is repeated.
+*/
+func (m *Matrix) broadcast(other *Matrix, f func(a, b float64) float64) *Matrix {
+	rows := broadcastDim(m.Rows, other.Rows)
+	cols := broadcastDim(m.Cols, other.Cols)
+	data := make([]float64, rows*cols)
+	if m.Rows == other.Rows && m.Cols == other.Cols {
+		for i := range data {
+			data[i] = f(m.Data[i], other.Data[i])
+		}
+		return NewMatrix(rows, cols, data)
+	}
+	for i := range rows {
+		for j := range cols {
+			data[i*cols+j] = f(m.Data[(i%m.Rows)*m.Cols+j%m.Cols], other.Data[(i%other.Rows)*other.Cols+j%other.Cols])
+		}
+	}
+	return NewMatrix(rows, cols, data)
+}
+
+func broadcastDim(a, b int) int {
+	switch {
+	case a == b:
+		return a
+	case a == 1:
+		return b
+	case b == 1:
+		return a
+	default:
+		panic("Matrix dimensions do not match")
+	}
+}
+
+/*
+sumTo reduces a gradient computed for a broadcast result back to the given
+shape, by summing over the dimensions that were broadcast.
+*/
+func (m *Matrix) sumTo(rows, cols int) *Matrix {
+	result := m
+	if rows != result.Rows {
+		if rows != 1 {
+			panic("Matrix dimensions do not match")
+		}
+		result = result.ColSum()
+	}
+	if cols != result.Cols {
+		if cols != 1 {
+			panic("Matrix dimensions do not match")
+		}
+		result = result.RowSum()
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package goraph
+
+import "math"
+
+/*
+Metric defines the interface for measuring the quality of network outputs
+against the targets. Name is used as the key of the metric in the training
+logs.
+*/
+type Metric interface {
+	Name() string
+	Compute(outputData, targetData [][]float64) float64
+}
+
+/*
+Accuracy is the fraction of samples whose largest output is at the same
+position as the largest target, as used for one-hot classification targets.
+*/
+type Accuracy struct{}
+
+func (a Accuracy) Name() string {
+	return "accuracy"
+}
+
+func (a Accuracy) Compute(outputData, targetData [][]float64) float64 {
+	correct := 0
+	for i := range outputData {
+		if argMax(outputData[i]) == argMax(targetData[i]) {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(outputData))
+}
+
+/*
+MeanAbsoluteError is the mean absolute difference between outputs and targets.
+*/
+type MeanAbsoluteError struct{}
+
+func (e MeanAbsoluteError) Name() string {
+	return "mae"
+}
+
+func (e MeanAbsoluteError) Compute(outputData, targetData [][]float64) float64 {
+	sum := 0.0
+	count := 0
+	for i := range outputData {
+		for j := range outputData[i] {
+			sum += math.Abs(outputData[i][j] - targetData[i][j])
+			count++
+		}
+	}
+	return sum / float64(count)
+}
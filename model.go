@@ -6,10 +6,24 @@ import (
 	"os"
 )
 
+/*
+Model defines the state that is saved to and loaded from a file: the
+parameters, the variables of State, which are not trained but are part of the
+model, such as the running statistics of BatchNorm, the normalizers and
+scalers of the data and, optionally, the learning rate scheduler. Normalizers
+and scalers are saved together with the name of their type, as registered
+with RegisterNormalizer and RegisterScaler, so a loaded model can transform
+raw data without being set up with the same scalers first. To restore a
+scheduler, Scheduler must be set to a scheduler of the same type before Load
+is called; otherwise the saved scheduler is skipped.
+*/
 type Model struct {
-	Parameters    []*VariableNode `json:"parameters"`
-	InputScalers  []Scaler        `json:"input_scalers"`
-	TargetScalers []Scaler        `json:"target_scalers"`
+	Parameters       []*VariableNode `json:"parameters"`
+	State            []*VariableNode `json:"state,omitempty"`
+	InputNormalizers []Normalizer    `json:"input_normalizers,omitempty"`
+	InputScalers     []Scaler        `json:"input_scalers"`
+	TargetScalers    []Scaler        `json:"target_scalers"`
+	Scheduler        Scheduler       `json:"scheduler,omitempty"`
 }
 
 func NewModel(parameters []*VariableNode, inputScalers, targetScalers []Scaler) *Model {
@@ -39,11 +53,115 @@ func (m *Model) Load(filePath string) error {
 		}
 		defer file.Close()
 		decoder := json.NewDecoder(file)
-		return decoder.Decode(m)
+		if err := decoder.Decode(m); err != nil {
+			return err
+		}
+		if m.Scheduler != nil {
+			m.Scheduler.Apply()
+		}
+		return nil
 	}
 	return err
 }
 
+/*
+modelJSON is the encoded form of a Model, with the normalizers and scalers
+replaced by their typed encoding.
+*/
+type modelJSON struct {
+	*modelFields
+	InputNormalizers []json.RawMessage `json:"input_normalizers,omitempty"`
+	InputScalers     []json.RawMessage `json:"input_scalers"`
+	TargetScalers    []json.RawMessage `json:"target_scalers"`
+	Scheduler        json.RawMessage   `json:"scheduler,omitempty"`
+}
+
+type modelFields Model
+
+func (m *Model) MarshalJSON() ([]byte, error) {
+	var err error
+	data := modelJSON{modelFields: (*modelFields)(m)}
+	if data.InputNormalizers, err = encodeSlice(normalizerRegistry, m.InputNormalizers); err != nil {
+		return nil, err
+	}
+	if data.InputScalers, err = encodeSlice(scalerRegistry, m.InputScalers); err != nil {
+		return nil, err
+	}
+	if data.TargetScalers, err = encodeSlice(scalerRegistry, m.TargetScalers); err != nil {
+		return nil, err
+	}
+	if m.Scheduler != nil {
+		if data.Scheduler, err = json.Marshal(m.Scheduler); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(data)
+}
+
+/*
+UnmarshalJSON decodes the parameters and state into the existing variable
+nodes, so the graphs built on them use the loaded values.
+*/
+func (m *Model) UnmarshalJSON(bytes []byte) error {
+	var err error
+	data := modelJSON{modelFields: (*modelFields)(m)}
+	if err = json.Unmarshal(bytes, &data); err != nil {
+		return err
+	}
+	if m.InputNormalizers, err = decodeSlice(normalizerRegistry, data.InputNormalizers, m.InputNormalizers); err != nil {
+		return err
+	}
+	if m.InputScalers, err = decodeSlice(scalerRegistry, data.InputScalers, m.InputScalers); err != nil {
+		return err
+	}
+	if m.TargetScalers, err = decodeSlice(scalerRegistry, data.TargetScalers, m.TargetScalers); err != nil {
+		return err
+	}
+	if m.Scheduler != nil && data.Scheduler != nil {
+		return json.Unmarshal(data.Scheduler, m.Scheduler)
+	}
+	return nil
+}
+
+/*
+TransformInputs applies the input normalizers and then the input scalers to
+raw input data, as required before passing it to the network.
+*/
+func (m *Model) TransformInputs(data [][]float64) [][]float64 {
+	for _, normalizer := range m.InputNormalizers {
+		data = normalizer.Normalize(data)
+	}
+	for _, scaler := range m.InputScalers {
+		data = scaler.Transform(data)
+	}
+	return data
+}
+
+/*
+TransformTargets applies the target scalers to raw target data.
+*/
+func (m *Model) TransformTargets(data [][]float64) [][]float64 {
+	for _, scaler := range m.TargetScalers {
+		data = scaler.Transform(data)
+	}
+	return data
+}
+
+/*
+InverseTransformTargets maps network outputs back to the scale of the raw
+targets. It panics if a target scaler does not implement InverseScaler.
+*/
+func (m *Model) InverseTransformTargets(data [][]float64) [][]float64 {
+	for i := len(m.TargetScalers) - 1; i >= 0; i-- {
+		scaler, ok := m.TargetScalers[i].(InverseScaler)
+		if !ok {
+			panic(fmt.Sprintf("Scaler %T cannot be inverted", m.TargetScalers[i]))
+		}
+		data = scaler.InverseTransform(data)
+	}
+	return data
+}
+
 func (m *Model) String() string {
 	return fmt.Sprintf("Parameters: %v, InputScalers: %v, TargetScalers: %v", m.Parameters, m.InputScalers, m.TargetScalers)
 }
@@ -1,8 +1,10 @@
 package goraph
 
 import (
+	"fmt"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"sync"
 )
 
@@ -17,13 +19,33 @@ type Node interface {
 }
 
 /*
-VariableNode defines a variable node.
+OperatorNode defines the interface for nodes computed from other nodes. Inputs
+returns the input nodes, and Gradients returns the gradient with respect to
+each input, in the same order, given the gradient of the node itself. A nil
+gradient means that nothing is propagated to the corresponding input.
+*/
+type OperatorNode interface {
+	Node
+	Inputs() []Node
+	Gradients(grad *Matrix) []*Matrix
+}
+
+/*
+VariableNode defines a variable node. A frozen variable accumulates no
+gradient and is not updated by optimizers, which keeps a pre-trained part of
+a network fixed while the rest is trained.
 */
 type VariableNode struct {
 	Name          string  `json:"name"`
 	Value         *Matrix `json:"value"`
 	Gradient      *Matrix `json:"-"`
+	Frozen        bool    `json:"frozen,omitempty"`
+	sparse        *Matrix
+	sparseRows    map[int]bool
 	gradientMutex sync.Mutex
+	// valueMutex guards the nodes that update Value in place during the
+	// forward pass, such as the running statistics of BatchNorm.
+	valueMutex sync.Mutex
 }
 
 func NewVariable(rows, cols int, data []float64) *VariableNode {
@@ -50,14 +72,87 @@ func NewRandomVariable(rows, cols int, f func() float64) *VariableNode {
 func (v *VariableNode) Forward() *Matrix {
 	return v.Value
 }
+
+/*
+Backward adds grad to the gradient of the variable. Unlike the arithmetic
+nodes, it does not broadcast: the nodes reduce a broadcast gradient to the
+shape of their operands themselves, so a gradient of another shape than the
+value is an error in the node that produced it.
+*/
 func (v *VariableNode) Backward(grad *Matrix) {
+	if v.Frozen {
+		return
+	}
+	if grad.Rows != v.Value.Rows || grad.Cols != v.Value.Cols {
+		panic("Gradient dimensions do not match the variable")
+	}
 	v.gradientMutex.Lock()
 	v.Gradient = v.Gradient.Add(grad)
 	v.gradientMutex.Unlock()
 }
+
+/*
+BackwardRows adds grad, whose rows are the gradients of the given rows of the
+variable, to its gradient, without touching the other rows. As long as a
+variable only receives gradients this way, it keeps track of the rows that
+have one, which lets optimizers update only those rows and Reset clear only
+those rows.
+*/
+func (v *VariableNode) BackwardRows(rows []int, grad *Matrix) {
+	if v.Frozen {
+		return
+	}
+	if grad.Rows != len(rows) || grad.Cols != v.Value.Cols {
+		panic("Matrix dimensions do not match")
+	}
+	v.gradientMutex.Lock()
+	for i, r := range rows {
+		row := v.Gradient.Data[r*v.Gradient.Cols : (r+1)*v.Gradient.Cols]
+		for j := range row {
+			row[j] += grad.Data[i*grad.Cols+j]
+		}
+		if v.sparse == v.Gradient {
+			if v.sparseRows == nil {
+				v.sparseRows = map[int]bool{}
+			}
+			v.sparseRows[r] = true
+		}
+	}
+	v.gradientMutex.Unlock()
+}
+
+/*
+GradientRows returns, in ascending order, the rows of the variable that
+received a gradient since the last Reset, if the gradient is known to be zero
+in all other rows, which is the case when it was only computed with
+BackwardRows. Otherwise ok is false and any row may have a gradient.
+*/
+func (v *VariableNode) GradientRows() (rows []int, ok bool) {
+	v.gradientMutex.Lock()
+	defer v.gradientMutex.Unlock()
+	if v.sparse != v.Gradient || v.sparseRows == nil {
+		return nil, false
+	}
+	rows = make([]int, 0, len(v.sparseRows))
+	for r := range v.sparseRows {
+		rows = append(rows, r)
+	}
+	slices.Sort(rows)
+	return rows, true
+}
+
 func (v *VariableNode) Reset() {
 	v.gradientMutex.Lock()
-	v.Gradient = NewConstMatrix(v.Value.Rows, v.Value.Cols, 0.0)
+	if v.sparse == v.Gradient && v.Gradient != nil && v.Gradient.Rows == v.Value.Rows && v.Gradient.Cols == v.Value.Cols {
+		for r := range v.sparseRows {
+			clear(v.Gradient.Data[r*v.Gradient.Cols : (r+1)*v.Gradient.Cols])
+		}
+	} else {
+		v.Gradient = NewConstMatrix(v.Value.Rows, v.Value.Cols, 0.0)
+	}
+	// The gradient is zero now, so its rows can be tracked until the next
+	// dense gradient replaces the matrix.
+	v.sparse, v.sparseRows = v.Gradient, nil
 	v.gradientMutex.Unlock()
 }
 func (v *VariableNode) Tag(name string) Node {
@@ -65,8 +160,22 @@ func (v *VariableNode) Tag(name string) Node {
 	return v
 }
 
+func (v *VariableNode) Freeze() {
+	v.Frozen = true
+}
+
+func (v *VariableNode) Unfreeze() {
+	v.Frozen = false
+}
+
+func (v *VariableNode) Trainable() bool {
+	return !v.Frozen
+}
+
 /*
-AddNode defines a node that performs matrix addition operations.
+AddNode defines a node that performs matrix addition operations. The operands
+are broadcast against each other, so a single-row operand such as a bias is
+added to every row of the other one, and a 1x1 operand to every element.
 */
 type AddNode struct {
 	X          Node
@@ -94,9 +203,16 @@ func (m *AddNode) Forward() *Matrix {
 	return m.Value
 }
 
+func (m *AddNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *AddNode) Gradients(grad *Matrix) []*Matrix {
+	x := m.X.Forward()
+	y := m.Y.Forward()
+	return []*Matrix{grad.sumTo(x.Rows, x.Cols), grad.sumTo(y.Rows, y.Cols)}
+}
 func (m *AddNode) Backward(grad *Matrix) {
-	m.X.Backward(grad)
-	m.Y.Backward(grad)
+	backward(m, grad)
 }
 
 func (m *AddNode) Reset() {
@@ -114,7 +230,8 @@ func (m *AddNode) Tag(name string) Node {
 }
 
 /*
-SubNode defines a node that performs matrix subtraction operations.
+SubNode defines a node that performs matrix subtraction operations, with the
+operands broadcast against each other.
 */
 type SubNode struct {
 	X          Node
@@ -140,9 +257,16 @@ func (m *SubNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
+func (m *SubNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *SubNode) Gradients(grad *Matrix) []*Matrix {
+	x := m.X.Forward()
+	y := m.Y.Forward()
+	return []*Matrix{grad.sumTo(x.Rows, x.Cols), grad.Negate().sumTo(y.Rows, y.Cols)}
+}
 func (m *SubNode) Backward(grad *Matrix) {
-	m.X.Backward(grad)
-	m.Y.Backward(grad.Negate())
+	backward(m, grad)
 }
 func (m *SubNode) Reset() {
 	m.valueMutex.Lock()
@@ -187,11 +311,16 @@ func (m *MultiNode) Forward() *Matrix {
 	return m.Value
 }
 
-func (m *MultiNode) Backward(grad *Matrix) {
+func (m *MultiNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *MultiNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
-	m.X.Backward(grad.Multi(y.Trans()))
-	m.Y.Backward(x.Trans().Multi(grad))
+	return []*Matrix{grad.Multi(y.Trans()), x.Trans().Multi(grad)}
+}
+func (m *MultiNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 
 func (m *MultiNode) Reset() {
@@ -210,7 +339,7 @@ func (m *MultiNode) Tag(name string) Node {
 
 /*
 MultiElementNode defines a node that performs matrix multiplication based on the
-corresponding elements.
+corresponding elements, with the operands broadcast against each other.
 */
 type MultiElementNode struct {
 	X          Node
@@ -236,17 +365,18 @@ func (m *MultiElementNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *MultiElementNode) Backward(grad *Matrix) {
+func (m *MultiElementNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *MultiElementNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
-	gradX := NewConstMatrix(x.Rows, x.Cols, 0)
-	gradY := NewConstMatrix(y.Rows, y.Cols, 0)
-	for i := range grad.Data {
-		gradX.Data[i] = y.Data[i] * grad.Data[i]
-		gradY.Data[i] = x.Data[i] * grad.Data[i]
-	}
-	m.X.Backward(gradX)
-	m.Y.Backward(gradY)
+	gradX := grad.MultiElement(y).sumTo(x.Rows, x.Cols)
+	gradY := grad.MultiElement(x).sumTo(y.Rows, y.Cols)
+	return []*Matrix{gradX, gradY}
+}
+func (m *MultiElementNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *MultiElementNode) Reset() {
 	m.valueMutex.Lock()
@@ -262,6 +392,10 @@ func (m *MultiElementNode) Tag(name string) Node {
 	return m
 }
 
+/*
+DivElementNode defines a node that performs matrix division based on the
+corresponding elements, with the operands broadcast against each other.
+*/
 type DivElementNode struct {
 	X          Node
 	Y          Node
@@ -287,13 +421,18 @@ func (m *DivElementNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *DivElementNode) Backward(grad *Matrix) {
+func (m *DivElementNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *DivElementNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
-	gradX := grad.DivElement(y)
-	gradY := grad.MultiElement(x)
-	m.X.Backward(gradX)
-	m.Y.Backward(gradY)
+	gradX := grad.DivElement(y).sumTo(x.Rows, x.Cols)
+	gradY := grad.MultiElement(x).DivElement(y.MultiElement(y)).Negate().sumTo(y.Rows, y.Cols)
+	return []*Matrix{gradX, gradY}
+}
+func (m *DivElementNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *DivElementNode) Reset() {
 	m.valueMutex.Lock()
@@ -335,13 +474,19 @@ func (m *LogNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *LogNode) Backward(grad *Matrix) {
+func (m *LogNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *LogNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	gradX := NewConstMatrix(x.Rows, x.Cols, 0)
 	for i := range x.Data {
 		gradX.Data[i] = grad.Data[i] / x.Data[i]
 	}
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *LogNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *LogNode) Reset() {
 	m.valueMutex.Lock()
@@ -377,9 +522,15 @@ func (m *TransNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *TransNode) Backward(grad *Matrix) {
+func (m *TransNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *TransNode) Gradients(grad *Matrix) []*Matrix {
 	gradX := grad.Trans()
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *TransNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *TransNode) Reset() {
 	m.valueMutex.Lock()
@@ -420,11 +571,17 @@ func (m *ReshapeNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ReshapeNode) Backward(grad *Matrix) {
+func (m *ReshapeNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ReshapeNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	xGrad := NewConstMatrix(x.Rows, x.Cols, 0)
 	copy(xGrad.Data, grad.Data)
-	m.X.Backward(xGrad)
+	return []*Matrix{xGrad}
+}
+func (m *ReshapeNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ReshapeNode) Reset() {
 	m.valueMutex.Lock()
@@ -468,7 +625,10 @@ func (m *HConcatNode) Forward() *Matrix {
 	return m.Value
 }
 
-func (m *HConcatNode) Backward(grad *Matrix) {
+func (m *HConcatNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *HConcatNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
 	var dataX, dataY []float64
@@ -478,8 +638,10 @@ func (m *HConcatNode) Backward(grad *Matrix) {
 	}
 	gradX := NewMatrix(x.Rows, x.Cols, dataX)
 	gradY := NewMatrix(y.Rows, y.Cols, dataY)
-	m.X.Backward(gradX)
-	m.Y.Backward(gradY)
+	return []*Matrix{gradX, gradY}
+}
+func (m *HConcatNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *HConcatNode) Reset() {
 	m.valueMutex.Lock()
@@ -522,15 +684,20 @@ func (m *VConcatNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *VConcatNode) Backward(grad *Matrix) {
+func (m *VConcatNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *VConcatNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
 	dataX := grad.Data[:x.Rows*grad.Cols]
 	dataY := grad.Data[x.Rows*grad.Cols:]
 	gradX := NewMatrix(x.Rows, x.Cols, dataX)
 	gradY := NewMatrix(y.Rows, y.Cols, dataY)
-	m.X.Backward(gradX)
-	m.Y.Backward(gradY)
+	return []*Matrix{gradX, gradY}
+}
+func (m *VConcatNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *VConcatNode) Reset() {
 	m.valueMutex.Lock()
@@ -575,7 +742,10 @@ func (m *RowSliceNode) Forward() *Matrix {
 	return m.Value
 }
 
-func (m *RowSliceNode) Backward(grad *Matrix) {
+func (m *RowSliceNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *RowSliceNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	myGrad := NewConstMatrix(x.Rows, x.Cols, 0)
 	for i := range m.End - m.Start {
@@ -583,7 +753,10 @@ func (m *RowSliceNode) Backward(grad *Matrix) {
 			myGrad.Data[(i+m.Start)*x.Cols+j] = grad.Data[i*x.Cols+j]
 		}
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *RowSliceNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *RowSliceNode) Reset() {
 	m.valueMutex.Lock()
@@ -625,7 +798,10 @@ func (m *ColSliceNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ColSliceNode) Backward(grad *Matrix) {
+func (m *ColSliceNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ColSliceNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	myGrad := NewConstMatrix(x.Rows, x.Cols, 0)
 	for i := range x.Rows {
@@ -633,7 +809,10 @@ func (m *ColSliceNode) Backward(grad *Matrix) {
 			myGrad.Data[i*x.Cols+j+m.Start] = grad.Data[i*grad.Cols+j]
 		}
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *ColSliceNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ColSliceNode) Reset() {
 	m.valueMutex.Lock()
@@ -670,7 +849,10 @@ func (m *RowSumNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *RowSumNode) Backward(grad *Matrix) {
+func (m *RowSumNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *RowSumNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	dataX := make([]float64, x.Rows*x.Cols)
 	for i := range x.Rows {
@@ -679,7 +861,10 @@ func (m *RowSumNode) Backward(grad *Matrix) {
 		}
 	}
 	gradX := NewMatrix(x.Rows, x.Cols, dataX)
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *RowSumNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *RowSumNode) Reset() {
 	m.valueMutex.Lock()
@@ -716,7 +901,10 @@ func (m *ColSumNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ColSumNode) Backward(grad *Matrix) {
+func (m *ColSumNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ColSumNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	dataX := make([]float64, x.Rows*x.Cols)
 	for i := range x.Rows {
@@ -725,7 +913,10 @@ func (m *ColSumNode) Backward(grad *Matrix) {
 		}
 	}
 	gradX := NewMatrix(x.Rows, x.Cols, dataX)
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *ColSumNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ColSumNode) Reset() {
 	m.valueMutex.Lock()
@@ -764,9 +955,15 @@ func (m *ScaleNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ScaleNode) Backward(grad *Matrix) {
+func (m *ScaleNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ScaleNode) Gradients(grad *Matrix) []*Matrix {
 	gradX := grad.Scale(m.Rate)
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *ScaleNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ScaleNode) Reset() {
 	m.valueMutex.Lock()
@@ -811,7 +1008,10 @@ func (m *ValueThresholdNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ValueThresholdNode) Backward(grad *Matrix) {
+func (m *ValueThresholdNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ValueThresholdNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	gradX := NewConstMatrix(x.Rows, x.Cols, 0)
 	for i := range grad.Data {
@@ -821,7 +1021,10 @@ func (m *ValueThresholdNode) Backward(grad *Matrix) {
 			gradX.Data[i] = grad.Data[i]
 		}
 	}
-	m.X.Backward(gradX)
+	return []*Matrix{gradX}
+}
+func (m *ValueThresholdNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ValueThresholdNode) Reset() {
 	m.valueMutex.Lock()
@@ -864,12 +1067,18 @@ func (m *SigmoidNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *SigmoidNode) Backward(grad *Matrix) {
+func (m *SigmoidNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SigmoidNode) Gradients(grad *Matrix) []*Matrix {
 	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
 	for i := range myGrad.Data {
 		myGrad.Data[i] = m.Value.Data[i] * (1 - m.Value.Data[i]) * grad.Data[i]
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *SigmoidNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *SigmoidNode) Reset() {
 	m.valueMutex.Lock()
@@ -917,7 +1126,10 @@ func (m *ReLuNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ReLuNode) Backward(grad *Matrix) {
+func (m *ReLuNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ReLuNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
 	for i, v := range x.Data {
@@ -927,7 +1139,10 @@ func (m *ReLuNode) Backward(grad *Matrix) {
 			myGrad.Data[i] = grad.Data[i] * 0.01
 		}
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *ReLuNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ReLuNode) Reset() {
 	m.valueMutex.Lock()
@@ -974,12 +1189,18 @@ func (m *TanhNode) Forward() *Matrix {
 	return m.Value
 }
 
-func (m *TanhNode) Backward(grad *Matrix) {
+func (m *TanhNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *TanhNode) Gradients(grad *Matrix) []*Matrix {
 	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
 	for i := range myGrad.Data {
 		myGrad.Data[i] = (1 - math.Pow(m.Value.Data[i], 2.0) + 0.001) * grad.Data[i]
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *TanhNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *TanhNode) Reset() {
 	m.valueMutex.Lock()
@@ -995,13 +1216,20 @@ func (m *TanhNode) Tag(name string) Node {
 }
 
 /*
-DropoutNode defines a node that performs Dropout operations.
+DropoutNode defines a node that performs inverted Dropout. In training mode,
+every element is kept with probability P and scaled by 1/P, so its expected
+value is unchanged, or dropped otherwise. In inference mode, set with
+SetTraining, the input passes through unchanged. The mask is drawn from the
+generator set with SetRand, or from the library random source if none is set.
 */
 type DropoutNode struct {
 	X          Node
 	P          float64 //Keep probability
 	Value      *Matrix
 	Name       string
+	mask       []float64
+	inference  bool
+	random     *rand.Rand
 	valueMutex sync.Mutex
 }
 
@@ -1015,29 +1243,43 @@ func (m *DropoutNode) Forward() *Matrix {
 	m.valueMutex.Lock()
 	if m.Value == nil {
 		x := m.X.Forward()
-		data := make([]float64, x.Rows*x.Cols)
-		for i := range data {
-			if rand.Float64() < m.P {
-				data[i] = x.Data[i]
-			} else {
-				data[i] = 0
+		if m.inference {
+			m.mask = nil
+			m.Value = x
+		} else {
+			random := m.random
+			if random == nil {
+				random = libraryRand
+			}
+			m.mask = make([]float64, len(x.Data))
+			data := make([]float64, len(x.Data))
+			for i := range data {
+				if random.Float64() < m.P {
+					m.mask[i] = 1 / m.P
+					data[i] = x.Data[i] * m.mask[i]
+				}
 			}
+			m.Value = NewMatrix(x.Rows, x.Cols, data)
 		}
-		m.Value = NewMatrix(x.Rows, x.Cols, data)
 	}
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *DropoutNode) Backward(grad *Matrix) {
-	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
-	for i := range myGrad.Data {
-		if m.Value.Data[i] == 0 {
-			myGrad.Data[i] = 0
-		} else {
-			myGrad.Data[i] = grad.Data[i]
-		}
+func (m *DropoutNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *DropoutNode) Gradients(grad *Matrix) []*Matrix {
+	if m.mask == nil {
+		return []*Matrix{grad}
+	}
+	data := make([]float64, len(grad.Data))
+	for i, v := range grad.Data {
+		data[i] = v * m.mask[i]
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{NewMatrix(grad.Rows, grad.Cols, data)}
+}
+func (m *DropoutNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *DropoutNode) Reset() {
 	m.valueMutex.Lock()
@@ -1051,12 +1293,24 @@ func (m *DropoutNode) Tag(name string) Node {
 	m.Name = name
 	return m
 }
+func (m *DropoutNode) SetRand(r *rand.Rand) {
+	m.valueMutex.Lock()
+	m.random = r
+	m.valueMutex.Unlock()
+}
+func (m *DropoutNode) SetTraining(training bool) {
+	m.valueMutex.Lock()
+	m.inference = !training
+	m.valueMutex.Unlock()
+}
 
 /*
-SoftmaxNode defines a node that executes the Softmax activation function
+SoftmaxNode defines a node that executes the Softmax activation function along
+the given axis, which is each row by default.
 */
 type SoftmaxNode struct {
 	X          Node
+	Axis       Axis
 	Value      *Matrix
 	Name       string
 	valueMutex sync.Mutex
@@ -1067,29 +1321,26 @@ func Softmax(x Node) *SoftmaxNode {
 		X: x,
 	}
 }
+
+func SoftmaxAxis(x Node, axis Axis) *SoftmaxNode {
+	return &SoftmaxNode{
+		X:    x,
+		Axis: axis,
+	}
+}
 func (m *SoftmaxNode) Forward() *Matrix {
 	m.valueMutex.Lock()
 	if m.Value == nil {
 		x := m.X.Forward()
 		data := make([]float64, x.Rows*x.Cols)
-		for i := range x.Rows {
-			sum := 0.0
-			values := make([]float64, x.Cols)
-			for j := range x.Cols {
-				values[j] = math.Exp(x.Data[i*x.Cols+j])
-				if math.IsInf(values[j], 0) {
-					panic("The value is infinity.")
-				}
-				if math.IsNaN(values[j]) {
-					panic("The value is NaN.")
-				}
-				sum += values[j]
-			}
-			if sum == 0.0 {
-				panic("Value of sum must not be 0.")
+		for _, lane := range x.lanes(m.Axis) {
+			values := make([]float64, len(lane))
+			for k, idx := range lane {
+				values[k] = x.Data[idx]
 			}
-			for j, v := range values {
-				data[i*x.Cols+j] = v / sum
+			lse := logSumExp(values)
+			for k, idx := range lane {
+				data[idx] = math.Exp(values[k] - lse)
 			}
 		}
 		m.Value = NewMatrix(x.Rows, x.Cols, data)
@@ -1097,12 +1348,24 @@ func (m *SoftmaxNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *SoftmaxNode) Backward(grad *Matrix) {
+func (m *SoftmaxNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *SoftmaxNode) Gradients(grad *Matrix) []*Matrix {
 	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
-	for i := range myGrad.Data {
-		myGrad.Data[i] = m.Value.Data[i] * (1 - m.Value.Data[i]) * grad.Data[i]
+	for _, lane := range m.Value.lanes(m.Axis) {
+		dot := 0.0
+		for _, idx := range lane {
+			dot += m.Value.Data[idx] * grad.Data[idx]
+		}
+		for _, idx := range lane {
+			myGrad.Data[idx] = m.Value.Data[idx] * (grad.Data[idx] - dot)
+		}
 	}
-	m.X.Backward(myGrad)
+	return []*Matrix{myGrad}
+}
+func (m *SoftmaxNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *SoftmaxNode) Reset() {
 	m.valueMutex.Lock()
@@ -1118,7 +1381,94 @@ func (m *SoftmaxNode) Tag(name string) Node {
 }
 
 /*
-MSELossNode defines a node for calculating mean square error loss.
+LogSoftmaxNode defines a node that computes the logarithm of the Softmax
+function along the given axis, which is each row by default. It is computed
+as x - log(sum(exp(x))), so it stays finite where Log(Softmax(x)) would not.
+*/
+type LogSoftmaxNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func LogSoftmax(x Node) *LogSoftmaxNode {
+	return &LogSoftmaxNode{
+		X: x,
+	}
+}
+
+func LogSoftmaxAxis(x Node, axis Axis) *LogSoftmaxNode {
+	return &LogSoftmaxNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+func (m *LogSoftmaxNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		data := make([]float64, x.Rows*x.Cols)
+		for _, lane := range x.lanes(m.Axis) {
+			values := make([]float64, len(lane))
+			for k, idx := range lane {
+				values[k] = x.Data[idx]
+			}
+			lse := logSumExp(values)
+			for k, idx := range lane {
+				data[idx] = values[k] - lse
+			}
+		}
+		m.Value = NewMatrix(x.Rows, x.Cols, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *LogSoftmaxNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *LogSoftmaxNode) Gradients(grad *Matrix) []*Matrix {
+	myGrad := NewConstMatrix(m.Value.Rows, m.Value.Cols, 0.0)
+	for _, lane := range m.Value.lanes(m.Axis) {
+		sum := 0.0
+		for _, idx := range lane {
+			sum += grad.Data[idx]
+		}
+		for _, idx := range lane {
+			myGrad.Data[idx] = grad.Data[idx] - math.Exp(m.Value.Data[idx])*sum
+		}
+	}
+	return []*Matrix{myGrad}
+}
+func (m *LogSoftmaxNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *LogSoftmaxNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *LogSoftmaxNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+MSELossNode defines a node for calculating mean square error loss, the mean
+over the rows of the mean squared error of each row.
+
+The gradient with respect to X is (X-Y)/(Rows*Cols), half the derivative of
+the loss, the convention the existing learning rates were tuned for. For a
+one-row output, such as the per-sample graphs of all the examples, this is
+the (X-Y)/Cols the loss has always used, so their learning rates carry over
+unchanged. An output of several rows, such as a mini-batch, gets the mean of
+the per-row gradients rather than their sum, matching the mean in the value;
+a model that trained a multi-row output with the earlier sum needs its
+learning rate multiplied by the number of rows to keep the same step size.
 */
 type MSELossNode struct {
 	X          Node
@@ -1158,20 +1508,22 @@ func (m *MSELossNode) Forward() *Matrix {
 	return m.Value
 }
 
-func (m *MSELossNode) Backward(grad *Matrix) {
-	if grad != nil {
-		panic("grad param of loss backward function must be nil")
-	}
+func (m *MSELossNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *MSELossNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
 	data := make([]float64, x.Rows*x.Cols)
 	for i := range data {
-		data[i] = (x.Data[i] - y.Data[i]) / float64(x.Cols)
+		data[i] = (x.Data[i] - y.Data[i]) / float64(x.Cols*x.Rows)
 	}
 	gx := NewMatrix(x.Rows, x.Cols, data)
 	gy := NewConstMatrix(x.Rows, x.Cols, 0.0).Sub(gx)
-	m.X.Backward(gx)
-	m.Y.Backward(gy)
+	return lossGradients(grad, gx, gy)
+}
+func (m *MSELossNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 
 func (m *MSELossNode) Reset() {
@@ -1224,10 +1576,10 @@ func (m *CrossEntropyLossNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *CrossEntropyLossNode) Backward(grad *Matrix) {
-	if grad != nil {
-		panic("grad param of loss backward function must be nil")
-	}
+func (m *CrossEntropyLossNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *CrossEntropyLossNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	y := m.Y.Forward()
 	dataX := make([]float64, x.Rows*x.Cols)
@@ -1248,8 +1600,10 @@ func (m *CrossEntropyLossNode) Backward(grad *Matrix) {
 	}
 	gradX := NewMatrix(x.Rows, x.Cols, dataX)
 	gradY := NewConstMatrix(y.Rows, y.Cols, 0)
-	m.X.Backward(gradX)
-	m.Y.Backward(gradY)
+	return lossGradients(grad, gradX, gradY)
+}
+func (m *CrossEntropyLossNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *CrossEntropyLossNode) Reset() {
 	m.valueMutex.Lock()
@@ -1265,6 +1619,196 @@ func (m *CrossEntropyLossNode) Tag(name string) Node {
 	return m
 }
 
+/*
+SoftmaxCrossEntropyNode defines a node that calculates the cross entropy loss
+directly from logits, using log-sum-exp so that large logits neither overflow
+nor lose precision. Each row of X holds the logits of one sample. Y holds
+either a probability distribution per row, or, when ClassIndices is set, one
+class index per row, which must be an integer in [0, X.Cols). Smoothing mixes
+the targets with the uniform distribution, and Weights, if set, holds one
+weight per class. The loss is averaged over the rows, and the gradient of X is
+exactly softmax(X) - target when no weights are used.
+*/
+type SoftmaxCrossEntropyNode struct {
+	X            Node
+	Y            Node
+	ClassIndices bool
+	Smoothing    float64
+	Weights      []float64
+	Value        *Matrix
+	Name         string
+	valueMutex   sync.Mutex
+}
+
+func SoftmaxCrossEntropy(x Node, y Node) *SoftmaxCrossEntropyNode {
+	return &SoftmaxCrossEntropyNode{
+		X: x,
+		Y: y,
+	}
+}
+
+/*
+SparseSoftmaxCrossEntropy creates a SoftmaxCrossEntropyNode whose targets are
+class indices, one per row of y.
+*/
+func SparseSoftmaxCrossEntropy(x Node, y Node) *SoftmaxCrossEntropyNode {
+	return &SoftmaxCrossEntropyNode{
+		X:            x,
+		Y:            y,
+		ClassIndices: true,
+	}
+}
+
+/*
+targets returns the smoothed target distribution of every row.
+*/
+func (m *SoftmaxCrossEntropyNode) targets(x, y *Matrix) *Matrix {
+	var target *Matrix
+	if m.ClassIndices {
+		if len(y.Data) != x.Rows {
+			panic("Number of class indices does not match the number of rows")
+		}
+		target = NewConstMatrix(x.Rows, x.Cols, 0)
+		for i, v := range y.Data {
+			if v != math.Trunc(v) || v < 0 || v >= float64(x.Cols) {
+				panic(fmt.Sprintf("Class index %v of row %d is not an integer in [0, %d)", v, i, x.Cols))
+			}
+			target.Data[i*x.Cols+int(v)] = 1
+		}
+	} else {
+		if x.Rows != y.Rows || x.Cols != y.Cols {
+			panic("Matrix dimensions do not match")
+		}
+		target = y
+	}
+	if m.Smoothing != 0 {
+		data := make([]float64, len(target.Data))
+		for i, v := range target.Data {
+			data[i] = (1-m.Smoothing)*v + m.Smoothing/float64(x.Cols)
+		}
+		target = NewMatrix(x.Rows, x.Cols, data)
+	}
+	return target
+}
+
+func (m *SoftmaxCrossEntropyNode) weight(class int) float64 {
+	if m.Weights == nil {
+		return 1
+	}
+	return m.Weights[class]
+}
+
+func (m *SoftmaxCrossEntropyNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		target := m.targets(x, m.Y.Forward())
+		if m.Weights != nil && len(m.Weights) != x.Cols {
+			panic("Number of class weights does not match the number of classes")
+		}
+		data := make([]float64, 1)
+		for i := range x.Rows {
+			row := x.Data[i*x.Cols : (i+1)*x.Cols]
+			lse := logSumExp(row)
+			for j, v := range row {
+				if t := target.Data[i*x.Cols+j]; t != 0 {
+					data[0] += m.weight(j) * t * (lse - v)
+				}
+			}
+		}
+		data[0] /= float64(x.Rows)
+		m.Value = NewMatrix(1, 1, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *SoftmaxCrossEntropyNode) Inputs() []Node {
+	return []Node{m.X, m.Y}
+}
+func (m *SoftmaxCrossEntropyNode) Gradients(grad *Matrix) []*Matrix {
+	x := m.X.Forward()
+	target := m.targets(x, m.Y.Forward())
+	rows := float64(x.Rows)
+	gradX := NewConstMatrix(x.Rows, x.Cols, 0)
+	var gradY *Matrix
+	if !m.ClassIndices {
+		gradY = NewConstMatrix(x.Rows, x.Cols, 0)
+	}
+	for i := range x.Rows {
+		row := x.Data[i*x.Cols : (i+1)*x.Cols]
+		lse := logSumExp(row)
+		weightSum := 0.0
+		for j := range row {
+			weightSum += m.weight(j) * target.Data[i*x.Cols+j]
+		}
+		for j, v := range row {
+			idx := i*x.Cols + j
+			gradX.Data[idx] = (weightSum*math.Exp(v-lse) - m.weight(j)*target.Data[idx]) / rows
+			if gradY != nil {
+				gradY.Data[idx] = (1 - m.Smoothing) * m.weight(j) * (lse - v) / rows
+			}
+		}
+	}
+	return lossGradients(grad, gradX, gradY)
+}
+func (m *SoftmaxCrossEntropyNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *SoftmaxCrossEntropyNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Y.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *SoftmaxCrossEntropyNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+lossGradients scales the gradients of a loss node by the gradient of the loss
+itself. That gradient is nil, meaning 1, when the loss is the root of the
+backward pass, and a 1x1 matrix when the loss is part of a larger expression,
+such as a loss plus a regularizer.
+*/
+func lossGradients(grad *Matrix, grads ...*Matrix) []*Matrix {
+	if grad == nil {
+		return grads
+	}
+	if grad.Rows != 1 || grad.Cols != 1 {
+		panic("Gradient of a loss must be 1x1")
+	}
+	if grad.Data[0] != 1 {
+		for i, g := range grads {
+			if g != nil {
+				grads[i] = g.Scale(grad.Data[0])
+			}
+		}
+	}
+	return grads
+}
+
+/*
+logSumExp returns log(sum(exp(values))) computed without overflow.
+*/
+func logSumExp(values []float64) float64 {
+	maxVal := math.Inf(-1)
+	for _, v := range values {
+		maxVal = max(maxVal, v)
+	}
+	if math.IsInf(maxVal, 0) {
+		return maxVal
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += math.Exp(v - maxVal)
+	}
+	return maxVal + math.Log(sum)
+}
+
 /*
 GradThresholdNode defines a processing node that, during forward propagation,
 does not perform any processing and directly passes the input to the next step.
@@ -1295,15 +1839,22 @@ func (m *GradThresholdNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *GradThresholdNode) Backward(grad *Matrix) {
+func (m *GradThresholdNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *GradThresholdNode) Gradients(grad *Matrix) []*Matrix {
 	mod := 0.0
 	for _, v := range grad.Data {
 		mod += math.Pow(v, 2)
 	}
 	mod = math.Sqrt(mod)
 	if mod >= m.Threshold {
-		m.X.Backward(grad)
+		return []*Matrix{grad}
 	}
+	return []*Matrix{nil}
+}
+func (m *GradThresholdNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *GradThresholdNode) Reset() {
 	m.valueMutex.Lock()
@@ -1391,13 +1942,19 @@ func (m *PoolNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *PoolNode) Backward(grad *Matrix) {
+func (m *PoolNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *PoolNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	xGrad := NewConstMatrix(x.Rows, x.Cols, 0)
 	for i, idx := range m.Flags {
 		xGrad.Data[idx] = grad.Data[i]
 	}
-	m.X.Backward(xGrad)
+	return []*Matrix{xGrad}
+}
+func (m *PoolNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *PoolNode) Reset() {
 	m.valueMutex.Lock()
@@ -1481,7 +2038,10 @@ func (m *ConvNode) Forward() *Matrix {
 	m.valueMutex.Unlock()
 	return m.Value
 }
-func (m *ConvNode) Backward(grad *Matrix) {
+func (m *ConvNode) Inputs() []Node {
+	return []Node{m.X, m.Kernel}
+}
+func (m *ConvNode) Gradients(grad *Matrix) []*Matrix {
 	x := m.X.Forward()
 	kernel := m.Kernel.Forward()
 	xGrad := NewConstMatrix(x.Rows, x.Cols, 0)
@@ -1522,8 +2082,10 @@ func (m *ConvNode) Backward(grad *Matrix) {
 			}
 		}
 	}
-	m.X.Backward(xGrad)
-	m.Kernel.Backward(kernelGrad)
+	return []*Matrix{xGrad, kernelGrad}
+}
+func (m *ConvNode) Backward(grad *Matrix) {
+	backward(m, grad)
 }
 func (m *ConvNode) Reset() {
 	m.valueMutex.Lock()
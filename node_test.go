@@ -0,0 +1,254 @@
+package goraph
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestMSELossGradientScale(t *testing.T) {
+	x := NewVariable(2, 2, []float64{1, 2, 3, 4})
+	y := NewVariable(2, 2, []float64{0, 0, 0, 0})
+	MSELoss(RowSlice(x, 0, 1), RowSlice(y, 0, 1)).Backward(nil)
+	// A single row keeps the gradient (X-Y)/Cols.
+	for i, want := range []float64{0.5, 1, 0, 0} {
+		if x.Gradient.Data[i] != want {
+			t.Fatalf("one row: got %v, want (X-Y)/Cols", x.Gradient.Data)
+		}
+	}
+	x.Reset()
+	MSELoss(x, y).Backward(nil)
+	for i, want := range []float64{0.25, 0.5, 0.75, 1} {
+		if x.Gradient.Data[i] != want {
+			t.Fatalf("two rows: got %v, want (X-Y)/(Rows*Cols)", x.Gradient.Data)
+		}
+	}
+}
+
+func TestVariableBackwardShape(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a gradient of the wrong shape did not panic")
+		}
+	}()
+	NewConstVariable(2, 3, 0).Backward(NewConstMatrix(1, 3, 1))
+}
+
+/*
+softTargets creates a variable whose rows are random probability
+distributions.
+*/
+func softTargets(r *rand.Rand, rows, cols int) *VariableNode {
+	y := NewRandomVariable(rows, cols, func() float64 { return r.Float64() + 0.1 })
+	for i := range rows {
+		row := y.Value.Data[i*cols : (i+1)*cols]
+		sum := 0.0
+		for _, v := range row {
+			sum += v
+		}
+		for j := range row {
+			row[j] /= sum
+		}
+	}
+	return y
+}
+
+func TestSoftmaxCrossEntropyGradients(t *testing.T) {
+	r := testRand()
+	t.Run("soft targets", func(t *testing.T) {
+		x, y := randomVariable(r, 3, 4), softTargets(r, 3, 4)
+		checkGradientsSeed(t, SoftmaxCrossEntropy(x, y), nil, x, y)
+	})
+	t.Run("label smoothing", func(t *testing.T) {
+		x, y := randomVariable(r, 3, 4), softTargets(r, 3, 4)
+		loss := SoftmaxCrossEntropy(x, y)
+		loss.Smoothing = 0.2
+		checkGradientsSeed(t, loss, nil, x, y)
+	})
+	t.Run("class indices", func(t *testing.T) {
+		x := randomVariable(r, 3, 4)
+		y := NewVariable(3, 1, []float64{2, 0, 3})
+		y.Freeze()
+		loss := SparseSoftmaxCrossEntropy(x, y)
+		loss.Smoothing = 0.1
+		checkGradientsSeed(t, loss, nil, x)
+	})
+	t.Run("class weights", func(t *testing.T) {
+		x, y := randomVariable(r, 3, 4), softTargets(r, 3, 4)
+		loss := SoftmaxCrossEntropy(x, y)
+		loss.Weights = []float64{0.5, 2, 1, 3}
+		checkGradientsSeed(t, loss, nil, x, y)
+		indices := NewVariable(3, 1, []float64{1, 3, 0})
+		indices.Freeze()
+		sparse := SparseSoftmaxCrossEntropy(x, indices)
+		sparse.Weights = loss.Weights
+		checkGradientsSeed(t, sparse, nil, x)
+	})
+}
+
+func TestSoftmaxCrossEntropyClassIndices(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 2, 3)
+	oneHot := NewVariable(2, 3, []float64{0, 0, 1, 1, 0, 0})
+	indices := NewVariable(2, 1, []float64{2, 0})
+	want := SoftmaxCrossEntropy(x, oneHot).Forward().Data[0]
+	if got := SparseSoftmaxCrossEntropy(x, indices).Forward().Data[0]; math.Abs(got-want) > 1e-12 {
+		t.Fatalf("loss of class indices %g, want the one-hot loss %g", got, want)
+	}
+	for _, class := range []float64{1.5, -1, 3, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("class index %v did not panic", class)
+				}
+			}()
+			SparseSoftmaxCrossEntropy(x, NewVariable(2, 1, []float64{0, class})).Forward()
+		}()
+	}
+}
+
+func TestSoftmaxAxisGradients(t *testing.T) {
+	r := testRand()
+	for _, axis := range []Axis{RowAxis, ColAxis} {
+		x := randomVariable(r, 3, 4)
+		checkGradients(t, project(r, SoftmaxAxis(x, axis), 3, 4), x)
+		x = randomVariable(r, 3, 4)
+		checkGradients(t, project(r, LogSoftmaxAxis(x, axis), 3, 4), x)
+	}
+}
+
+func TestSoftmaxAxisLanes(t *testing.T) {
+	x := NewVariable(2, 3, []float64{1, 2, 3, -1, 0, 5})
+	rows := SoftmaxAxis(x, RowAxis).Forward()
+	cols := SoftmaxAxis(x, ColAxis).Forward()
+	for i := range 2 {
+		if sum := rows.Data[i*3] + rows.Data[i*3+1] + rows.Data[i*3+2]; math.Abs(sum-1) > 1e-12 {
+			t.Fatalf("row %d of the row softmax sums to %g", i, sum)
+		}
+	}
+	for j := range 3 {
+		if sum := cols.Data[j] + cols.Data[3+j]; math.Abs(sum-1) > 1e-12 {
+			t.Fatalf("column %d of the column softmax sums to %g", j, sum)
+		}
+	}
+	logCols := LogSoftmaxAxis(x, ColAxis).Forward()
+	for i, v := range logCols.Data {
+		if math.Abs(math.Exp(v)-cols.Data[i]) > 1e-12 {
+			t.Fatalf("exp of the column log-softmax %v does not match the softmax %v", logCols.Data, cols.Data)
+		}
+	}
+}
+
+func TestCrossEntropyLossGradient(t *testing.T) {
+	x := NewVariable(2, 2, []float64{0.25, 0.75, 0.5, 0.5})
+	y := NewVariable(2, 2, []float64{0, 1, 1, 0})
+	CrossEntropyLoss(x, y).Backward(nil)
+	// -1/x for the target class and 1/(1-x) for the others.
+	for i, want := range []float64{1 / 0.75, -1 / 0.75, -2, 2} {
+		if math.Abs(x.Gradient.Data[i]-want) > 1e-12 {
+			t.Fatalf("gradient %v, want -1/x for the target and 1/(1-x) otherwise", x.Gradient.Data)
+		}
+	}
+}
+
+func TestBroadcastGradients(t *testing.T) {
+	ops := []struct {
+		name string
+		f    func(x, y Node) Node
+	}{
+		{"Add", func(x, y Node) Node { return Add(x, y) }},
+		{"Sub", func(x, y Node) Node { return Sub(x, y) }},
+		{"MultiElement", func(x, y Node) Node { return MultiElement(x, y) }},
+		{"Div", func(x, y Node) Node { return Div(x, y) }},
+	}
+	shapes := []struct {
+		name       string
+		rows, cols int
+	}{
+		{"row vector", 1, 4},
+		{"column vector", 3, 1},
+		{"scalar", 1, 1},
+	}
+	r := testRand()
+	// Operands of Div stay in [1, 2), away from a zero denominator.
+	operand := func(rows, cols int) *VariableNode {
+		return NewRandomVariable(rows, cols, func() float64 { return r.Float64() + 1 })
+	}
+	for _, op := range ops {
+		for _, shape := range shapes {
+			t.Run(op.name+" "+shape.name, func(t *testing.T) {
+				x, y := operand(3, 4), operand(shape.rows, shape.cols)
+				checkGradients(t, project(r, op.f(x, y), 3, 4), x, y)
+				x, y = operand(shape.rows, shape.cols), operand(3, 4)
+				checkGradients(t, project(r, op.f(x, y), 3, 4), x, y)
+			})
+		}
+	}
+}
+
+func TestBroadcastShapeMismatch(t *testing.T) {
+	for _, f := range []func(x, y Node) Node{
+		func(x, y Node) Node { return Add(x, y) },
+		func(x, y Node) Node { return Sub(x, y) },
+		func(x, y Node) Node { return MultiElement(x, y) },
+		func(x, y Node) Node { return Div(x, y) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("operands of shapes 3x4 and 2x4 did not panic")
+				}
+			}()
+			f(NewConstVariable(3, 4, 1), NewConstVariable(2, 4, 1)).Forward()
+		}()
+	}
+}
+
+func TestDropoutInference(t *testing.T) {
+	x := NewVariable(2, 3, []float64{1, -2, 3, 0.5, 4, -1})
+	dropout := Dropout(x, 0.5)
+	SetTraining(dropout, false)
+	if !slices.Equal(dropout.Forward().Data, x.Value.Data) {
+		t.Fatalf("inference output %v, want the input %v", dropout.Value.Data, x.Value.Data)
+	}
+	dropout.Backward(NewConstMatrix(2, 3, 1))
+	for _, g := range x.Gradient.Data {
+		if g != 1 {
+			t.Fatalf("inference gradient %v, want the incoming gradient", x.Gradient.Data)
+		}
+	}
+}
+
+func TestDropoutExpectedValue(t *testing.T) {
+	const rows, cols, keep = 200, 200, 0.7
+	x := NewConstVariable(rows, cols, 2)
+	dropout := Dropout(x, keep)
+	dropout.SetRand(testRand())
+	dropout.Forward()
+	kept, sum := 0, 0.0
+	for _, v := range dropout.Value.Data {
+		switch {
+		case v == 0:
+		case math.Abs(v-2/keep) < 1e-12:
+			kept++
+		default:
+			t.Fatalf("output %g is neither dropped nor scaled by 1/P", v)
+		}
+		sum += v
+	}
+	if fraction := float64(kept) / (rows * cols); math.Abs(fraction-keep) > 0.01 {
+		t.Fatalf("kept %g of the elements, want %g", fraction, keep)
+	}
+	// The standard error of the mean is about 2*sqrt((1-P)/P)/200 ≈ 0.007.
+	if mean := sum / (rows * cols); math.Abs(mean-2) > 0.03 {
+		t.Fatalf("mean output %g, want the mean input 2", mean)
+	}
+	// The gradient is scaled by the same mask.
+	dropout.Backward(NewConstMatrix(rows, cols, 1))
+	for i, g := range x.Gradient.Data {
+		if g != dropout.Value.Data[i]/2 {
+			t.Fatalf("gradient %g of element %d, want the mask %g", g, i, dropout.Value.Data[i]/2)
+		}
+	}
+}
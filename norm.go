@@ -0,0 +1,456 @@
+package goraph
+
+import (
+	"math"
+	"sync"
+)
+
+/*
+normalizeLanes normalizes x within every lane, a set of indices into its data,
+to zero mean and unit variance. It returns the normalized data, the inverse
+standard deviation of every lane, and the mean and biased variance of every
+lane.
+*/
+func normalizeLanes(x *Matrix, lanes [][]int, eps float64) (xHat, invStd, mean, variance []float64) {
+	xHat = make([]float64, len(x.Data))
+	invStd = make([]float64, len(lanes))
+	mean = make([]float64, len(lanes))
+	variance = make([]float64, len(lanes))
+	for l, lane := range lanes {
+		for _, idx := range lane {
+			mean[l] += x.Data[idx]
+		}
+		mean[l] /= float64(len(lane))
+		for _, idx := range lane {
+			d := x.Data[idx] - mean[l]
+			variance[l] += d * d
+		}
+		variance[l] /= float64(len(lane))
+		invStd[l] = 1 / math.Sqrt(variance[l]+eps)
+		for _, idx := range lane {
+			xHat[idx] = (x.Data[idx] - mean[l]) * invStd[l]
+		}
+	}
+	return
+}
+
+/*
+normalizeLanesGradient returns the gradient with respect to the input of
+normalizeLanes, given the gradient with respect to its normalized output.
+*/
+func normalizeLanesGradient(gradXHat, xHat, invStd []float64, lanes [][]int) []float64 {
+	result := make([]float64, len(xHat))
+	for l, lane := range lanes {
+		n := float64(len(lane))
+		sum, dot := 0.0, 0.0
+		for _, idx := range lane {
+			sum += gradXHat[idx]
+			dot += gradXHat[idx] * xHat[idx]
+		}
+		for _, idx := range lane {
+			result[idx] = invStd[l] / n * (n*gradXHat[idx] - sum - xHat[idx]*dot)
+		}
+	}
+	return result
+}
+
+/*
+affineGradients returns the gradients of xHat*gamma+beta, with gamma and beta
+broadcast against xHat, with respect to xHat, gamma and beta.
+*/
+func affineGradients(grad, xHat, gamma, beta *Matrix) (gradXHat, gradGamma, gradBeta *Matrix) {
+	gradXHat = grad.MultiElement(gamma)
+	gradGamma = grad.MultiElement(xHat).sumTo(gamma.Rows, gamma.Cols)
+	gradBeta = grad.sumTo(beta.Rows, beta.Cols)
+	return
+}
+
+/*
+channelAffine returns xHat*gamma+beta for feature maps in the layout of
+Conv2D, [N*C, H*W], where gamma and beta hold one value per channel.
+*/
+func channelAffine(xHat, gamma, beta *Matrix, channels int) *Matrix {
+	if len(gamma.Data) != channels || len(beta.Data) != channels {
+		panic("Gamma and beta must have one value per channel")
+	}
+	data := make([]float64, len(xHat.Data))
+	for i := range xHat.Rows {
+		c := i % channels
+		for j := range xHat.Cols {
+			data[i*xHat.Cols+j] = xHat.Data[i*xHat.Cols+j]*gamma.Data[c] + beta.Data[c]
+		}
+	}
+	return NewMatrix(xHat.Rows, xHat.Cols, data)
+}
+
+/*
+channelAffineGradients is affineGradients for channelAffine.
+*/
+func channelAffineGradients(grad, xHat, gamma, beta *Matrix, channels int) (gradXHat, gradGamma, gradBeta *Matrix) {
+	gradXHat = NewConstMatrix(grad.Rows, grad.Cols, 0)
+	gradGamma = NewConstMatrix(gamma.Rows, gamma.Cols, 0)
+	gradBeta = NewConstMatrix(beta.Rows, beta.Cols, 0)
+	for i := range grad.Rows {
+		c := i % channels
+		for j := range grad.Cols {
+			idx := i*grad.Cols + j
+			gradXHat.Data[idx] = grad.Data[idx] * gamma.Data[c]
+			gradGamma.Data[c] += grad.Data[idx] * xHat.Data[idx]
+			gradBeta.Data[c] += grad.Data[idx]
+		}
+	}
+	return
+}
+
+/*
+BatchNormNode defines a node that performs batch normalization: every column
+of X, one feature over the samples of a batch in its rows, is normalized to
+zero mean and unit variance and then scaled by Gamma and shifted by Beta,
+which are usually 1xC variables broadcast over the rows.
+
+If Channels is set, as by BatchNorm2D, X holds feature maps in the layout of
+Conv2D, [N*C, H*W], and every channel is normalized over the N*H*W values of
+all samples and positions. Gamma, Beta and the running statistics then hold
+one value per channel.
+
+In training mode, the statistics of the batch are used, and RunningMean and
+RunningVar are updated as moving averages with the given Momentum. In
+inference mode, set with SetTraining, the running statistics are used
+instead. The running statistics are frozen variables, so they are not
+trained; add them to Model.State so that they are saved and loaded along with
+the parameters, as a model loaded without them normalizes with the initial
+statistics. Every running statistic is locked while it is updated, so graphs
+that share it, such as those a batch network builds for different batch
+sizes, can run concurrently without blocking unrelated layers. Batch
+normalization needs batches of several samples, as processed by a
+network created with NewBatchNeuralNetwork.
+*/
+type BatchNormNode struct {
+	X           Node
+	Gamma       Node
+	Beta        Node
+	RunningMean *VariableNode
+	RunningVar  *VariableNode
+	Channels    int
+	Momentum    float64
+	Eps         float64
+	Value       *Matrix
+	Name        string
+	xHat        *Matrix
+	invStd      []float64
+	inference   bool
+	valueMutex  sync.Mutex
+}
+
+/*
+NewBatchNormVariables creates the variables of a batch normalization over the
+given number of features, or of channels for BatchNorm2D: Gamma initialized
+to ones, Beta to zeros, and the frozen running mean and variance initialized
+to zeros and ones. Gamma and Beta go to the optimizer and Model.Parameters,
+the running mean and variance to Model.State.
+*/
+func NewBatchNormVariables(features int) (gamma, beta, runningMean, runningVar *VariableNode) {
+	gamma = NewConstVariable(1, features, 1)
+	beta = NewConstVariable(1, features, 0)
+	runningMean = NewConstVariable(1, features, 0)
+	runningVar = NewConstVariable(1, features, 1)
+	runningMean.Freeze()
+	runningVar.Freeze()
+	return
+}
+
+func BatchNorm(x, gamma, beta Node, runningMean, runningVar *VariableNode) *BatchNormNode {
+	return &BatchNormNode{
+		X:           x,
+		Gamma:       gamma,
+		Beta:        beta,
+		RunningMean: runningMean,
+		RunningVar:  runningVar,
+		Momentum:    0.1,
+		Eps:         1e-5,
+	}
+}
+
+/*
+BatchNorm2D creates a BatchNormNode for feature maps with the given number of
+channels in the layout of Conv2D, [N*C, H*W].
+*/
+func BatchNorm2D(x, gamma, beta Node, runningMean, runningVar *VariableNode, channels int) *BatchNormNode {
+	m := BatchNorm(x, gamma, beta, runningMean, runningVar)
+	m.Channels = channels
+	return m
+}
+
+/*
+lanes returns the indices of the elements of every feature, the columns of x,
+or, if Channels is set, the rows of every channel.
+*/
+func (m *BatchNormNode) lanes(x *Matrix) [][]int {
+	if m.Channels == 0 {
+		return x.lanes(ColAxis)
+	}
+	if x.Rows%m.Channels != 0 {
+		panic("Number of rows must be a multiple of the number of channels")
+	}
+	lanes := make([][]int, m.Channels)
+	for i := range x.Rows {
+		c := i % m.Channels
+		for j := range x.Cols {
+			lanes[c] = append(lanes[c], i*x.Cols+j)
+		}
+	}
+	return lanes
+}
+
+func (m *BatchNormNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		lanes := m.lanes(x)
+		if len(m.RunningMean.Value.Data) != len(lanes) || len(m.RunningVar.Value.Data) != len(lanes) {
+			panic("Running statistics must have one value per feature")
+		}
+		if m.inference {
+			data := make([]float64, len(x.Data))
+			m.invStd = make([]float64, len(lanes))
+			for j, lane := range lanes {
+				m.invStd[j] = 1 / math.Sqrt(m.RunningVar.Value.Data[j]+m.Eps)
+				for _, idx := range lane {
+					data[idx] = (x.Data[idx] - m.RunningMean.Value.Data[j]) * m.invStd[j]
+				}
+			}
+			m.xHat = NewMatrix(x.Rows, x.Cols, data)
+		} else {
+			n := len(lanes[0])
+			if n < 2 {
+				panic("Batch normalization needs at least two values per feature")
+			}
+			xHat, invStd, mean, variance := normalizeLanes(x, lanes, m.Eps)
+			m.xHat, m.invStd = NewMatrix(x.Rows, x.Cols, xHat), invStd
+			unbiased := float64(n) / float64(n-1)
+			m.RunningMean.valueMutex.Lock()
+			for j := range lanes {
+				m.RunningMean.Value.Data[j] = (1-m.Momentum)*m.RunningMean.Value.Data[j] + m.Momentum*mean[j]
+			}
+			m.RunningMean.valueMutex.Unlock()
+			m.RunningVar.valueMutex.Lock()
+			for j := range lanes {
+				m.RunningVar.Value.Data[j] = (1-m.Momentum)*m.RunningVar.Value.Data[j] + m.Momentum*variance[j]*unbiased
+			}
+			m.RunningVar.valueMutex.Unlock()
+		}
+		if m.Channels == 0 {
+			m.Value = m.xHat.MultiElement(m.Gamma.Forward()).Add(m.Beta.Forward())
+		} else {
+			m.Value = channelAffine(m.xHat, m.Gamma.Forward(), m.Beta.Forward(), m.Channels)
+		}
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *BatchNormNode) Inputs() []Node {
+	return []Node{m.X, m.Gamma, m.Beta}
+}
+func (m *BatchNormNode) Gradients(grad *Matrix) []*Matrix {
+	var gradXHat, gradGamma, gradBeta *Matrix
+	if m.Channels == 0 {
+		gradXHat, gradGamma, gradBeta = affineGradients(grad, m.xHat, m.Gamma.Forward(), m.Beta.Forward())
+	} else {
+		gradXHat, gradGamma, gradBeta = channelAffineGradients(grad, m.xHat, m.Gamma.Forward(), m.Beta.Forward(), m.Channels)
+	}
+	lanes := m.lanes(m.xHat)
+	var gradX *Matrix
+	if m.inference {
+		gradX = NewConstMatrix(grad.Rows, grad.Cols, 0)
+		for j, lane := range lanes {
+			for _, idx := range lane {
+				gradX.Data[idx] = gradXHat.Data[idx] * m.invStd[j]
+			}
+		}
+	} else {
+		data := normalizeLanesGradient(gradXHat.Data, m.xHat.Data, m.invStd, lanes)
+		gradX = NewMatrix(grad.Rows, grad.Cols, data)
+	}
+	return []*Matrix{gradX, gradGamma, gradBeta}
+}
+func (m *BatchNormNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *BatchNormNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Gamma.Reset()
+		m.Beta.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *BatchNormNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+func (m *BatchNormNode) SetTraining(training bool) {
+	m.valueMutex.Lock()
+	m.inference = !training
+	m.valueMutex.Unlock()
+}
+
+/*
+LayerNormNode defines a node that performs layer normalization: every row of
+X, one sample, is normalized to zero mean and unit variance over its columns
+and then scaled by Gamma and shifted by Beta, which are usually 1xC
+variables. Unlike batch normalization, it behaves the same during training
+and inference.
+*/
+type LayerNormNode struct {
+	X          Node
+	Gamma      Node
+	Beta       Node
+	Eps        float64
+	Value      *Matrix
+	Name       string
+	xHat       *Matrix
+	invStd     []float64
+	valueMutex sync.Mutex
+}
+
+func LayerNorm(x, gamma, beta Node) *LayerNormNode {
+	return &LayerNormNode{
+		X:     x,
+		Gamma: gamma,
+		Beta:  beta,
+		Eps:   1e-5,
+	}
+}
+
+func (m *LayerNormNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		xHat, invStd, _, _ := normalizeLanes(x, x.lanes(RowAxis), m.Eps)
+		m.xHat, m.invStd = NewMatrix(x.Rows, x.Cols, xHat), invStd
+		m.Value = m.xHat.MultiElement(m.Gamma.Forward()).Add(m.Beta.Forward())
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *LayerNormNode) Inputs() []Node {
+	return []Node{m.X, m.Gamma, m.Beta}
+}
+func (m *LayerNormNode) Gradients(grad *Matrix) []*Matrix {
+	gradXHat, gradGamma, gradBeta := affineGradients(grad, m.xHat, m.Gamma.Forward(), m.Beta.Forward())
+	data := normalizeLanesGradient(gradXHat.Data, m.xHat.Data, m.invStd, m.xHat.lanes(RowAxis))
+	return []*Matrix{NewMatrix(grad.Rows, grad.Cols, data), gradGamma, gradBeta}
+}
+func (m *LayerNormNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *LayerNormNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Gamma.Reset()
+		m.Beta.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *LayerNormNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+GroupNormNode defines a node that performs group normalization on feature
+maps in the layout of Conv2D, [N*C, H*W], with one channel of one sample per
+row. The C channels of every sample are divided into Groups groups of
+consecutive channels, and every group is normalized over its channels and
+positions. Gamma and Beta are Cx1 variables holding the scale and shift of
+every channel. Like layer normalization, it does not depend on the batch and
+behaves the same during training and inference.
+*/
+type GroupNormNode struct {
+	X          Node
+	Gamma      Node
+	Beta       Node
+	Channels   int
+	Groups     int
+	Eps        float64
+	Value      *Matrix
+	Name       string
+	xHat       *Matrix
+	invStd     []float64
+	valueMutex sync.Mutex
+}
+
+func GroupNorm(x, gamma, beta Node, channels, groups int) *GroupNormNode {
+	if channels%groups != 0 {
+		panic("Number of channels must be a multiple of the number of groups")
+	}
+	return &GroupNormNode{
+		X:        x,
+		Gamma:    gamma,
+		Beta:     beta,
+		Channels: channels,
+		Groups:   groups,
+		Eps:      1e-5,
+	}
+}
+
+/*
+lanes returns the indices of the elements of every group of every sample.
+*/
+func (m *GroupNormNode) lanes(x *Matrix) [][]int {
+	if x.Rows%m.Channels != 0 {
+		panic("Number of rows must be a multiple of the number of channels")
+	}
+	perGroup := m.Channels / m.Groups
+	lanes := make([][]int, x.Rows/perGroup)
+	for l := range lanes {
+		lanes[l] = make([]int, 0, perGroup*x.Cols)
+		for i := l * perGroup; i < (l+1)*perGroup; i++ {
+			for j := range x.Cols {
+				lanes[l] = append(lanes[l], i*x.Cols+j)
+			}
+		}
+	}
+	return lanes
+}
+
+func (m *GroupNormNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		x := m.X.Forward()
+		xHat, invStd, _, _ := normalizeLanes(x, m.lanes(x), m.Eps)
+		m.xHat, m.invStd = NewMatrix(x.Rows, x.Cols, xHat), invStd
+		m.Value = channelAffine(m.xHat, m.Gamma.Forward(), m.Beta.Forward(), m.Channels)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *GroupNormNode) Inputs() []Node {
+	return []Node{m.X, m.Gamma, m.Beta}
+}
+func (m *GroupNormNode) Gradients(grad *Matrix) []*Matrix {
+	gradXHat, gradGamma, gradBeta := channelAffineGradients(grad, m.xHat, m.Gamma.Forward(), m.Beta.Forward(), m.Channels)
+	data := normalizeLanesGradient(gradXHat.Data, m.xHat.Data, m.invStd, m.lanes(m.xHat))
+	return []*Matrix{NewMatrix(grad.Rows, grad.Cols, data), gradGamma, gradBeta}
+}
+func (m *GroupNormNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *GroupNormNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.Gamma.Reset()
+		m.Beta.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *GroupNormNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
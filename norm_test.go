@@ -0,0 +1,98 @@
+package goraph
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchNormGradients(t *testing.T) {
+	for _, training := range []bool{true, false} {
+		r := testRand()
+		x := randomVariable(r, 5, 3)
+		gamma, beta := randomVariable(r, 1, 3), randomVariable(r, 1, 3)
+		_, _, runningMean, runningVar := NewBatchNormVariables(3)
+		norm := BatchNorm(x, gamma, beta, runningMean, runningVar)
+		norm.SetTraining(training)
+		checkGradients(t, project(r, norm, 5, 3), x, gamma, beta)
+	}
+}
+
+func TestLayerNormGradients(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 4, 5)
+	gamma, beta := randomVariable(r, 1, 5), randomVariable(r, 1, 5)
+	checkGradients(t, project(r, LayerNorm(x, gamma, beta), 4, 5), x, gamma, beta)
+}
+
+func TestGroupNormGradients(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 2*4, 3)
+	gamma, beta := randomVariable(r, 4, 1), randomVariable(r, 4, 1)
+	checkGradients(t, project(r, GroupNorm(x, gamma, beta, 4, 2), 2*4, 3), x, gamma, beta)
+}
+
+func TestBatchNormRunningStatsSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	gamma, beta, runningMean, runningVar := NewBatchNormVariables(2)
+	x := NewVariable(2, 2, []float64{1, 2, 3, 6})
+	BatchNorm(x, gamma, beta, runningMean, runningVar).Forward()
+	model := NewModel([]*VariableNode{gamma, beta}, nil, nil)
+	model.State = []*VariableNode{runningMean, runningVar}
+	if err := model.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	gamma2, beta2, runningMean2, runningVar2 := NewBatchNormVariables(2)
+	loaded := NewModel([]*VariableNode{gamma2, beta2}, nil, nil)
+	loaded.State = []*VariableNode{runningMean2, runningVar2}
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	for j := range 2 {
+		if runningMean2.Value.Data[j] != runningMean.Value.Data[j] || runningVar2.Value.Data[j] != runningVar.Value.Data[j] {
+			t.Fatalf("loaded running statistics %v %v, want %v %v", runningMean2.Value.Data, runningVar2.Value.Data, runningMean.Value.Data, runningVar.Value.Data)
+		}
+	}
+	if !runningMean2.Frozen || !runningVar2.Frozen {
+		t.Fatal("loaded running statistics are not frozen")
+	}
+}
+
+func TestBatchNorm2DGradients(t *testing.T) {
+	for _, training := range []bool{true, false} {
+		r := testRand()
+		x := randomVariable(r, 2*3, 4)
+		gamma, beta := randomVariable(r, 3, 1), randomVariable(r, 3, 1)
+		_, _, runningMean, runningVar := NewBatchNormVariables(3)
+		runningVar.Value = NewConstMatrix(1, 3, 0.5)
+		norm := BatchNorm2D(x, gamma, beta, runningMean, runningVar, 3)
+		norm.SetTraining(training)
+		checkGradients(t, project(r, norm, 2*3, 4), x, gamma, beta)
+	}
+}
+
+func TestBatchNorm2DStatistics(t *testing.T) {
+	// Two samples of two channels with two positions: channel 0 holds 1, 3,
+	// 5, 7 and channel 1 holds 2, 2, 4, 8.
+	x := NewVariable(4, 2, []float64{1, 3, 2, 2, 5, 7, 4, 8})
+	gamma, beta, runningMean, runningVar := NewBatchNormVariables(2)
+	norm := BatchNorm2D(x, gamma, beta, runningMean, runningVar, 2)
+	norm.Momentum = 1
+	value := norm.Forward()
+	checkValues(t, "running mean", runningMean.Value.Data, []float64{4, 4})
+	// The biased variances are 5 and 6, the unbiased 20/3 and 8.
+	checkValues(t, "running variance", runningVar.Value.Data, []float64{20.0 / 3, 8})
+	for c := range 2 {
+		sum, squares := 0.0, 0.0
+		for i := c; i < 4; i += 2 {
+			for _, v := range value.Data[i*2 : (i+1)*2] {
+				sum += v
+				squares += v * v
+			}
+		}
+		if math.Abs(sum) > 1e-9 || math.Abs(squares/4-1) > 1e-5 {
+			t.Fatalf("channel %d has the mean %g and variance %g, want 0 and 1", c, sum/4, squares/4)
+		}
+	}
+}
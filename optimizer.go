@@ -1,12 +1,90 @@
 package goraph
 
-import "math"
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"math"
+)
 
 type Optimizer interface {
 	Step(batchSize int)
 	Reset()
 }
 
+/*
+StatefulOptimizer is implemented by optimizers that keep state between steps,
+such as velocities or moment estimates. MarshalState encodes that state, and
+UnmarshalState restores it into an optimizer created for the same parameters,
+so a training resumed from a checkpoint continues exactly where it stopped.
+*/
+type StatefulOptimizer interface {
+	Optimizer
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+/*
+ParameterOptimizer is implemented by optimizers that expose the parameters
+they update, which is needed to clip or accumulate their gradients.
+*/
+type ParameterOptimizer interface {
+	Optimizer
+	Params() []*VariableNode
+}
+
+/*
+checkStateShapes reports an error unless the saved state matrices have the
+shapes of the current ones.
+*/
+func checkStateShapes(name string, saved, current []*Matrix) error {
+	if len(saved) != len(current) {
+		return fmt.Errorf("optimizer state %s has %d matrices, expected %d", name, len(saved), len(current))
+	}
+	for i := range saved {
+		if saved[i] == nil || saved[i].Rows != current[i].Rows || saved[i].Cols != current[i].Cols || len(saved[i].Data) != len(current[i].Data) {
+			return fmt.Errorf("optimizer state %s[%d] does not match the shape of the parameter", name, i)
+		}
+	}
+	return nil
+}
+
+/*
+gradientElements iterates over the elements of the gradient of p that an
+optimizer visits: all of them, or, if only some rows of p received a gradient
+through BackwardRows, as in an Embedding lookup, only the elements of those
+rows. The other rows keep their values and optimizer state until they are
+used again, so large embedding tables are updated lazily. LAMB always visits
+every element, as its step depends on the norm of the whole parameter.
+
+The optimizers update the elements of Value.Data in place rather than
+replacing the matrix, which is what keeps a lazy update as cheap as the rows
+it visits. Value.Data therefore changes under anyone else holding it, such as
+a caller that passed its own slice to NewVariable; clone the data first if the
+original values must be kept.
+*/
+func gradientElements(p *VariableNode) iter.Seq2[int, float64] {
+	return func(yield func(int, float64) bool) {
+		rows, ok := p.GradientRows()
+		if !ok {
+			for j, g := range p.Gradient.Data {
+				if !yield(j, g) {
+					return
+				}
+			}
+			return
+		}
+		cols := p.Gradient.Cols
+		for _, r := range rows {
+			for j := r * cols; j < (r+1)*cols; j++ {
+				if !yield(j, p.Gradient.Data[j]) {
+					return
+				}
+			}
+		}
+	}
+}
+
 type SGDOptimizer struct {
 	LearningRate float64
 	Momentum     float64
@@ -28,18 +106,60 @@ func NewSGDOptimizer(parameters []*VariableNode, learningRate, momentum float64)
 }
 func (opt *SGDOptimizer) Step(batchSize int) {
 	for i, p := range opt.Parameters {
-		grad := p.Gradient.Scale(1 / float64(batchSize))
-		opt.Velocity[i] = opt.Velocity[i].Scale(opt.Momentum).Add(grad.Scale(1 - opt.Momentum))
-		p.Value = p.Value.Sub(opt.Velocity[i].Scale(opt.LearningRate))
+		if p.Frozen {
+			continue
+		}
+		v := opt.Velocity[i].Data
+		for j, g := range gradientElements(p) {
+			g *= 1 / float64(batchSize)
+			v[j] = v[j]*opt.Momentum + g*(1-opt.Momentum)
+			p.Value.Data[j] -= v[j] * opt.LearningRate
+		}
 	}
 }
 
+func (opt *SGDOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *SGDOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *SGDOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *SGDOptimizer) SetMomentum(momentum float64) {
+	opt.Momentum = momentum
+}
+
 func (opt *SGDOptimizer) Reset() {
 	for i := range opt.Velocity {
 		opt.Velocity[i] = NewConstMatrix(opt.Velocity[i].Rows, opt.Velocity[i].Cols, 0)
 	}
 }
 
+type sgdState struct {
+	Velocity []*Matrix `json:"velocity"`
+}
+
+func (opt *SGDOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(sgdState{Velocity: opt.Velocity})
+}
+
+func (opt *SGDOptimizer) UnmarshalState(data []byte) error {
+	var state sgdState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("velocity", state.Velocity, opt.Velocity); err != nil {
+		return err
+	}
+	opt.Velocity = state.Velocity
+	return nil
+}
+
 type AdamOptimizer struct {
 	LearningRate float64
 	Beta1        float64
@@ -74,10 +194,13 @@ func NewAdamOptimizer(parameters []*VariableNode, learningRate, beta1, beta2, ep
 
 func (opt *AdamOptimizer) Step(batchSize int) {
 	for i, p := range opt.Parameters {
-		grad := p.Gradient.Scale(1 / float64(batchSize))
-		for j := range grad.Data {
-			m := opt.Beta1*opt.M[i].Data[j] + (1-opt.Beta1)*grad.Data[j]
-			v := opt.Beta2*opt.V[i].Data[j] + (1-opt.Beta2)*math.Pow(grad.Data[j], 2)
+		if p.Frozen {
+			continue
+		}
+		for j, g := range gradientElements(p) {
+			g *= 1 / float64(batchSize)
+			m := opt.Beta1*opt.M[i].Data[j] + (1-opt.Beta1)*g
+			v := opt.Beta2*opt.V[i].Data[j] + (1-opt.Beta2)*math.Pow(g, 2)
 			mHat := m / (1 - math.Pow(opt.Beta1, float64(opt.T)))
 			vHat := v / (1 - math.Pow(opt.Beta2, float64(opt.T)))
 			update := opt.LearningRate * mHat / (math.Sqrt(vHat) + opt.Eps)
@@ -89,6 +212,22 @@ func (opt *AdamOptimizer) Step(batchSize int) {
 	opt.T++
 }
 
+func (opt *AdamOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *AdamOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *AdamOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *AdamOptimizer) SetMomentum(momentum float64) {
+	opt.Beta1 = momentum
+}
+
 func (opt *AdamOptimizer) Reset() {
 	for i := range opt.M {
 		opt.M[i] = NewConstMatrix(opt.M[i].Rows, opt.M[i].Cols, 0)
@@ -98,3 +237,819 @@ func (opt *AdamOptimizer) Reset() {
 	}
 	opt.T = 1
 }
+
+type adamState struct {
+	M []*Matrix `json:"m"`
+	V []*Matrix `json:"v"`
+	T int       `json:"t"`
+}
+
+func (opt *AdamOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(adamState{M: opt.M, V: opt.V, T: opt.T})
+}
+
+func (opt *AdamOptimizer) UnmarshalState(data []byte) error {
+	var state adamState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("m", state.M, opt.M); err != nil {
+		return err
+	}
+	if err := checkStateShapes("v", state.V, opt.V); err != nil {
+		return err
+	}
+	opt.M, opt.V, opt.T = state.M, state.V, state.T
+	return nil
+}
+
+/*
+zerosLike returns one zero matrix per parameter, of the shape of its value.
+*/
+func zerosLike(parameters []*VariableNode) []*Matrix {
+	result := make([]*Matrix, len(parameters))
+	for i, p := range parameters {
+		result[i] = NewConstMatrix(p.Value.Rows, p.Value.Cols, 0)
+	}
+	return result
+}
+
+func clearMatrices(matrices []*Matrix) {
+	for _, m := range matrices {
+		clear(m.Data)
+	}
+}
+
+/*
+MomentumOptimizer implements SGD with classic or, if Nesterov is set, Nesterov
+momentum: the velocity accumulates the gradients as v = Momentum*v + g, and the
+parameters move by -LearningRate*v, or by -LearningRate*(g + Momentum*v) with
+Nesterov momentum. Unlike SGDOptimizer, the gradient is not scaled by
+1-Momentum, so the effective step size grows with the momentum.
+*/
+type MomentumOptimizer struct {
+	LearningRate float64
+	Momentum     float64
+	Nesterov     bool
+	Velocity     []*Matrix
+	Parameters   []*VariableNode
+}
+
+func NewMomentumOptimizer(parameters []*VariableNode, learningRate, momentum float64) *MomentumOptimizer {
+	return &MomentumOptimizer{
+		LearningRate: learningRate,
+		Momentum:     momentum,
+		Velocity:     zerosLike(parameters),
+		Parameters:   parameters,
+	}
+}
+
+func NewNesterovOptimizer(parameters []*VariableNode, learningRate, momentum float64) *MomentumOptimizer {
+	opt := NewMomentumOptimizer(parameters, learningRate, momentum)
+	opt.Nesterov = true
+	return opt
+}
+
+func (opt *MomentumOptimizer) Step(batchSize int) {
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		v := opt.Velocity[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			v[j] = opt.Momentum*v[j] + g
+			if opt.Nesterov {
+				p.Value.Data[j] -= opt.LearningRate * (g + opt.Momentum*v[j])
+			} else {
+				p.Value.Data[j] -= opt.LearningRate * v[j]
+			}
+		}
+	}
+}
+
+func (opt *MomentumOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *MomentumOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *MomentumOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *MomentumOptimizer) SetMomentum(momentum float64) {
+	opt.Momentum = momentum
+}
+
+func (opt *MomentumOptimizer) Reset() {
+	clearMatrices(opt.Velocity)
+}
+
+func (opt *MomentumOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(sgdState{Velocity: opt.Velocity})
+}
+
+func (opt *MomentumOptimizer) UnmarshalState(data []byte) error {
+	var state sgdState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("velocity", state.Velocity, opt.Velocity); err != nil {
+		return err
+	}
+	opt.Velocity = state.Velocity
+	return nil
+}
+
+/*
+AdamWOptimizer implements Adam with decoupled weight decay: every step the
+parameters shrink by LearningRate*WeightDecay times their value, independently
+of the adaptive gradient update.
+*/
+type AdamWOptimizer struct {
+	LearningRate float64
+	Beta1        float64
+	Beta2        float64
+	Eps          float64
+	WeightDecay  float64
+	M            []*Matrix
+	V            []*Matrix
+	T            int
+	Parameters   []*VariableNode
+}
+
+func NewAdamWOptimizer(parameters []*VariableNode, learningRate, beta1, beta2, eps, weightDecay float64) *AdamWOptimizer {
+	return &AdamWOptimizer{
+		LearningRate: learningRate,
+		Beta1:        beta1,
+		Beta2:        beta2,
+		Eps:          eps,
+		WeightDecay:  weightDecay,
+		M:            zerosLike(parameters),
+		V:            zerosLike(parameters),
+		T:            1,
+		Parameters:   parameters,
+	}
+}
+
+func (opt *AdamWOptimizer) Step(batchSize int) {
+	correction1 := 1 - math.Pow(opt.Beta1, float64(opt.T))
+	correction2 := 1 - math.Pow(opt.Beta2, float64(opt.T))
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		m, v := opt.M[i].Data, opt.V[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			m[j] = opt.Beta1*m[j] + (1-opt.Beta1)*g
+			v[j] = opt.Beta2*v[j] + (1-opt.Beta2)*g*g
+			p.Value.Data[j] -= opt.LearningRate * opt.WeightDecay * p.Value.Data[j]
+			p.Value.Data[j] -= opt.LearningRate * (m[j] / correction1) / (math.Sqrt(v[j]/correction2) + opt.Eps)
+		}
+	}
+	opt.T++
+}
+
+func (opt *AdamWOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *AdamWOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *AdamWOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *AdamWOptimizer) SetMomentum(momentum float64) {
+	opt.Beta1 = momentum
+}
+
+func (opt *AdamWOptimizer) Reset() {
+	clearMatrices(opt.M)
+	clearMatrices(opt.V)
+	opt.T = 1
+}
+
+func (opt *AdamWOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(adamState{M: opt.M, V: opt.V, T: opt.T})
+}
+
+func (opt *AdamWOptimizer) UnmarshalState(data []byte) error {
+	var state adamState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("m", state.M, opt.M); err != nil {
+		return err
+	}
+	if err := checkStateShapes("v", state.V, opt.V); err != nil {
+		return err
+	}
+	opt.M, opt.V, opt.T = state.M, state.V, state.T
+	return nil
+}
+
+/*
+AMSGradOptimizer implements the AMSGrad variant of Adam, which divides by the
+largest second moment estimate seen so far, VMax, so the effective step size
+never grows.
+*/
+type AMSGradOptimizer struct {
+	LearningRate float64
+	Beta1        float64
+	Beta2        float64
+	Eps          float64
+	M            []*Matrix
+	V            []*Matrix
+	VMax         []*Matrix
+	T            int
+	Parameters   []*VariableNode
+}
+
+func NewAMSGradOptimizer(parameters []*VariableNode, learningRate, beta1, beta2, eps float64) *AMSGradOptimizer {
+	return &AMSGradOptimizer{
+		LearningRate: learningRate,
+		Beta1:        beta1,
+		Beta2:        beta2,
+		Eps:          eps,
+		M:            zerosLike(parameters),
+		V:            zerosLike(parameters),
+		VMax:         zerosLike(parameters),
+		T:            1,
+		Parameters:   parameters,
+	}
+}
+
+func (opt *AMSGradOptimizer) Step(batchSize int) {
+	correction1 := 1 - math.Pow(opt.Beta1, float64(opt.T))
+	correction2 := 1 - math.Pow(opt.Beta2, float64(opt.T))
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		m, v, vMax := opt.M[i].Data, opt.V[i].Data, opt.VMax[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			m[j] = opt.Beta1*m[j] + (1-opt.Beta1)*g
+			v[j] = opt.Beta2*v[j] + (1-opt.Beta2)*g*g
+			vMax[j] = max(vMax[j], v[j])
+			p.Value.Data[j] -= opt.LearningRate * (m[j] / correction1) / (math.Sqrt(vMax[j]/correction2) + opt.Eps)
+		}
+	}
+	opt.T++
+}
+
+func (opt *AMSGradOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *AMSGradOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *AMSGradOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *AMSGradOptimizer) SetMomentum(momentum float64) {
+	opt.Beta1 = momentum
+}
+
+func (opt *AMSGradOptimizer) Reset() {
+	clearMatrices(opt.M)
+	clearMatrices(opt.V)
+	clearMatrices(opt.VMax)
+	opt.T = 1
+}
+
+type amsGradState struct {
+	M    []*Matrix `json:"m"`
+	V    []*Matrix `json:"v"`
+	VMax []*Matrix `json:"v_max"`
+	T    int       `json:"t"`
+}
+
+func (opt *AMSGradOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(amsGradState{M: opt.M, V: opt.V, VMax: opt.VMax, T: opt.T})
+}
+
+func (opt *AMSGradOptimizer) UnmarshalState(data []byte) error {
+	var state amsGradState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("m", state.M, opt.M); err != nil {
+		return err
+	}
+	if err := checkStateShapes("v", state.V, opt.V); err != nil {
+		return err
+	}
+	if err := checkStateShapes("v_max", state.VMax, opt.VMax); err != nil {
+		return err
+	}
+	opt.M, opt.V, opt.VMax, opt.T = state.M, state.V, state.VMax, state.T
+	return nil
+}
+
+/*
+RMSpropOptimizer divides the gradient by the root of a moving average of its
+square, S, with decay rate Rho.
+*/
+type RMSpropOptimizer struct {
+	LearningRate float64
+	Rho          float64
+	Eps          float64
+	S            []*Matrix
+	Parameters   []*VariableNode
+}
+
+func NewRMSpropOptimizer(parameters []*VariableNode, learningRate, rho, eps float64) *RMSpropOptimizer {
+	return &RMSpropOptimizer{
+		LearningRate: learningRate,
+		Rho:          rho,
+		Eps:          eps,
+		S:            zerosLike(parameters),
+		Parameters:   parameters,
+	}
+}
+
+func (opt *RMSpropOptimizer) Step(batchSize int) {
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		s := opt.S[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			s[j] = opt.Rho*s[j] + (1-opt.Rho)*g*g
+			p.Value.Data[j] -= opt.LearningRate * g / (math.Sqrt(s[j]) + opt.Eps)
+		}
+	}
+}
+
+func (opt *RMSpropOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *RMSpropOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *RMSpropOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *RMSpropOptimizer) SetMomentum(momentum float64) {
+	opt.Rho = momentum
+}
+
+func (opt *RMSpropOptimizer) Reset() {
+	clearMatrices(opt.S)
+}
+
+type squareState struct {
+	S []*Matrix `json:"s"`
+}
+
+func (opt *RMSpropOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(squareState{S: opt.S})
+}
+
+func (opt *RMSpropOptimizer) UnmarshalState(data []byte) error {
+	var state squareState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("s", state.S, opt.S); err != nil {
+		return err
+	}
+	opt.S = state.S
+	return nil
+}
+
+/*
+AdagradOptimizer divides the gradient by the root of the sum of all its
+squares so far, S, so frequently updated parameters take smaller steps.
+*/
+type AdagradOptimizer struct {
+	LearningRate float64
+	Eps          float64
+	S            []*Matrix
+	Parameters   []*VariableNode
+}
+
+func NewAdagradOptimizer(parameters []*VariableNode, learningRate, eps float64) *AdagradOptimizer {
+	return &AdagradOptimizer{
+		LearningRate: learningRate,
+		Eps:          eps,
+		S:            zerosLike(parameters),
+		Parameters:   parameters,
+	}
+}
+
+func (opt *AdagradOptimizer) Step(batchSize int) {
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		s := opt.S[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			s[j] += g * g
+			p.Value.Data[j] -= opt.LearningRate * g / (math.Sqrt(s[j]) + opt.Eps)
+		}
+	}
+}
+
+func (opt *AdagradOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *AdagradOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *AdagradOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *AdagradOptimizer) Reset() {
+	clearMatrices(opt.S)
+}
+
+func (opt *AdagradOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(squareState{S: opt.S})
+}
+
+func (opt *AdagradOptimizer) UnmarshalState(data []byte) error {
+	var state squareState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("s", state.S, opt.S); err != nil {
+		return err
+	}
+	opt.S = state.S
+	return nil
+}
+
+/*
+AdadeltaOptimizer scales the gradient by the ratio of the roots of moving
+averages of the squared updates, D, and of the squared gradients, S, both with
+decay rate Rho. The update needs no tuned learning rate; LearningRate only
+scales it and is usually 1.
+*/
+type AdadeltaOptimizer struct {
+	LearningRate float64
+	Rho          float64
+	Eps          float64
+	S            []*Matrix
+	D            []*Matrix
+	Parameters   []*VariableNode
+}
+
+func NewAdadeltaOptimizer(parameters []*VariableNode, learningRate, rho, eps float64) *AdadeltaOptimizer {
+	return &AdadeltaOptimizer{
+		LearningRate: learningRate,
+		Rho:          rho,
+		Eps:          eps,
+		S:            zerosLike(parameters),
+		D:            zerosLike(parameters),
+		Parameters:   parameters,
+	}
+}
+
+func (opt *AdadeltaOptimizer) Step(batchSize int) {
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		s, d := opt.S[i].Data, opt.D[i].Data
+		for j, g := range gradientElements(p) {
+			g /= float64(batchSize)
+			s[j] = opt.Rho*s[j] + (1-opt.Rho)*g*g
+			delta := math.Sqrt(d[j]+opt.Eps) / math.Sqrt(s[j]+opt.Eps) * g
+			d[j] = opt.Rho*d[j] + (1-opt.Rho)*delta*delta
+			p.Value.Data[j] -= opt.LearningRate * delta
+		}
+	}
+}
+
+func (opt *AdadeltaOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *AdadeltaOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *AdadeltaOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *AdadeltaOptimizer) Reset() {
+	clearMatrices(opt.S)
+	clearMatrices(opt.D)
+}
+
+type adadeltaState struct {
+	S []*Matrix `json:"s"`
+	D []*Matrix `json:"d"`
+}
+
+func (opt *AdadeltaOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(adadeltaState{S: opt.S, D: opt.D})
+}
+
+func (opt *AdadeltaOptimizer) UnmarshalState(data []byte) error {
+	var state adadeltaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("s", state.S, opt.S); err != nil {
+		return err
+	}
+	if err := checkStateShapes("d", state.D, opt.D); err != nil {
+		return err
+	}
+	opt.S, opt.D = state.S, state.D
+	return nil
+}
+
+/*
+LAMBOptimizer implements layer-wise adaptive moments: the Adam update of a
+parameter, plus WeightDecay times its value, is rescaled by the trust ratio
+of the norm of the parameter to the norm of the update, which keeps large
+batch training stable.
+*/
+type LAMBOptimizer struct {
+	LearningRate float64
+	Beta1        float64
+	Beta2        float64
+	Eps          float64
+	WeightDecay  float64
+	M            []*Matrix
+	V            []*Matrix
+	T            int
+	Parameters   []*VariableNode
+}
+
+func NewLAMBOptimizer(parameters []*VariableNode, learningRate, beta1, beta2, eps, weightDecay float64) *LAMBOptimizer {
+	return &LAMBOptimizer{
+		LearningRate: learningRate,
+		Beta1:        beta1,
+		Beta2:        beta2,
+		Eps:          eps,
+		WeightDecay:  weightDecay,
+		M:            zerosLike(parameters),
+		V:            zerosLike(parameters),
+		T:            1,
+		Parameters:   parameters,
+	}
+}
+
+func (opt *LAMBOptimizer) Step(batchSize int) {
+	correction1 := 1 - math.Pow(opt.Beta1, float64(opt.T))
+	correction2 := 1 - math.Pow(opt.Beta2, float64(opt.T))
+	for i, p := range opt.Parameters {
+		if p.Frozen {
+			continue
+		}
+		m, v := opt.M[i].Data, opt.V[i].Data
+		update := make([]float64, len(p.Value.Data))
+		weightNorm, updateNorm := 0.0, 0.0
+		for j, g := range p.Gradient.Data {
+			g /= float64(batchSize)
+			m[j] = opt.Beta1*m[j] + (1-opt.Beta1)*g
+			v[j] = opt.Beta2*v[j] + (1-opt.Beta2)*g*g
+			update[j] = (m[j]/correction1)/(math.Sqrt(v[j]/correction2)+opt.Eps) + opt.WeightDecay*p.Value.Data[j]
+			weightNorm += p.Value.Data[j] * p.Value.Data[j]
+			updateNorm += update[j] * update[j]
+		}
+		trust := 1.0
+		if weightNorm > 0 && updateNorm > 0 {
+			trust = math.Sqrt(weightNorm) / math.Sqrt(updateNorm)
+		}
+		for j := range update {
+			p.Value.Data[j] -= opt.LearningRate * trust * update[j]
+		}
+	}
+	opt.T++
+}
+
+func (opt *LAMBOptimizer) Params() []*VariableNode {
+	return opt.Parameters
+}
+
+func (opt *LAMBOptimizer) GetLearningRate() float64 {
+	return opt.LearningRate
+}
+
+func (opt *LAMBOptimizer) SetLearningRate(learningRate float64) {
+	opt.LearningRate = learningRate
+}
+
+func (opt *LAMBOptimizer) SetMomentum(momentum float64) {
+	opt.Beta1 = momentum
+}
+
+func (opt *LAMBOptimizer) Reset() {
+	clearMatrices(opt.M)
+	clearMatrices(opt.V)
+	opt.T = 1
+}
+
+func (opt *LAMBOptimizer) MarshalState() ([]byte, error) {
+	return json.Marshal(adamState{M: opt.M, V: opt.V, T: opt.T})
+}
+
+func (opt *LAMBOptimizer) UnmarshalState(data []byte) error {
+	var state adamState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkStateShapes("m", state.M, opt.M); err != nil {
+		return err
+	}
+	if err := checkStateShapes("v", state.V, opt.V); err != nil {
+		return err
+	}
+	opt.M, opt.V, opt.T = state.M, state.V, state.T
+	return nil
+}
+
+/*
+ParamGroup is a set of parameters trained with their own hyperparameters.
+LearningRate is the learning rate of the optimizer that the factory of a
+GroupOptimizer creates for the group. WeightDecay is applied by the
+GroupOptimizer itself, whatever that optimizer is: WeightDecay times the value
+of a parameter is added to its gradient before every step, the L2 penalty of
+plain SGD weight decay. Momentum, if not zero, replaces the momentum of an
+optimizer that implements MomentumSetter; a zero Momentum keeps the one the
+factory set.
+*/
+type ParamGroup struct {
+	Parameters   []*VariableNode
+	LearningRate float64
+	WeightDecay  float64
+	Momentum     float64
+}
+
+/*
+MomentumSetter is implemented by optimizers with a momentum, so that a
+GroupOptimizer can apply the Momentum of a group: the momentum of SGD, the
+first moment decay rate Beta1 of the Adam family and the decay rate Rho of
+RMSprop.
+*/
+type MomentumSetter interface {
+	Optimizer
+	SetMomentum(momentum float64)
+}
+
+/*
+GroupOptimizer trains each parameter group with its own optimizer, created by
+the factory passed to NewGroupOptimizer, for example a small learning rate for
+a pre-trained backbone and a large one for a new head.
+
+The learning rate of a GroupOptimizer is that of its first group. Setting it,
+as a Scheduler does, scales the learning rates of all groups by the same
+factor, so the ratios between the groups are kept.
+*/
+type GroupOptimizer struct {
+	Groups     []ParamGroup
+	Optimizers []Optimizer
+	Factor     float64
+}
+
+/*
+NewGroupOptimizer creates one optimizer per group with factory and sets the
+Momentum of the group on it. It panics if a parameter appears in more than
+one group.
+*/
+func NewGroupOptimizer(groups []ParamGroup, factory func(group ParamGroup) Optimizer) *GroupOptimizer {
+	seen := make(map[*VariableNode]bool)
+	optimizers := make([]Optimizer, len(groups))
+	for i, group := range groups {
+		for _, p := range group.Parameters {
+			if seen[p] {
+				panic("Parameter is in more than one group")
+			}
+			seen[p] = true
+		}
+		optimizers[i] = factory(group)
+		if o, ok := optimizers[i].(MomentumSetter); ok && group.Momentum != 0 {
+			o.SetMomentum(group.Momentum)
+		}
+	}
+	return &GroupOptimizer{
+		Groups:     groups,
+		Optimizers: optimizers,
+		Factor:     1,
+	}
+}
+
+/*
+Step adds the weight decay of every group to the gradients of its trainable
+parameters and steps the optimizers. The gradients are sums over batchSize
+samples, so the decay is multiplied by batchSize to add WeightDecay times the
+value to the mean gradient the optimizers use. As the decay reaches every
+element, it turns off the lazy row updates of an Embedding table.
+*/
+func (opt *GroupOptimizer) Step(batchSize int) {
+	for i, o := range opt.Optimizers {
+		if decay := opt.Groups[i].WeightDecay; decay != 0 {
+			for _, p := range opt.Groups[i].Parameters {
+				if !p.Frozen {
+					p.Gradient = p.Gradient.Add(p.Value.Scale(decay * float64(batchSize)))
+				}
+			}
+		}
+		o.Step(batchSize)
+	}
+}
+
+func (opt *GroupOptimizer) Reset() {
+	for _, o := range opt.Optimizers {
+		o.Reset()
+	}
+}
+
+/*
+Params returns the parameters of all groups.
+*/
+func (opt *GroupOptimizer) Params() []*VariableNode {
+	var params []*VariableNode
+	for _, group := range opt.Groups {
+		params = append(params, group.Parameters...)
+	}
+	return params
+}
+
+func (opt *GroupOptimizer) GetLearningRate() float64 {
+	return opt.Groups[0].LearningRate * opt.Factor
+}
+
+/*
+SetLearningRate scales the learning rates of all groups. It has no effect if
+the learning rate of the first group is zero, and it skips optimizers that do
+not implement LearningRateOptimizer.
+*/
+func (opt *GroupOptimizer) SetLearningRate(learningRate float64) {
+	if opt.Groups[0].LearningRate == 0 {
+		return
+	}
+	opt.Factor = learningRate / opt.Groups[0].LearningRate
+	for i, o := range opt.Optimizers {
+		if o, ok := o.(LearningRateOptimizer); ok {
+			o.SetLearningRate(opt.Groups[i].LearningRate * opt.Factor)
+		}
+	}
+}
+
+/*
+MarshalState saves the state of every group optimizer that implements
+StatefulOptimizer, and null for the others.
+*/
+func (opt *GroupOptimizer) MarshalState() ([]byte, error) {
+	states := make([]json.RawMessage, len(opt.Optimizers))
+	for i, o := range opt.Optimizers {
+		states[i] = json.RawMessage("null")
+		if o, ok := o.(StatefulOptimizer); ok {
+			state, err := o.MarshalState()
+			if err != nil {
+				return nil, err
+			}
+			states[i] = state
+		}
+	}
+	return json.Marshal(states)
+}
+
+func (opt *GroupOptimizer) UnmarshalState(data []byte) error {
+	var states []json.RawMessage
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	if len(states) != len(opt.Optimizers) {
+		return fmt.Errorf("optimizer state has %d groups, expected %d", len(states), len(opt.Optimizers))
+	}
+	for i, o := range opt.Optimizers {
+		if string(states[i]) == "null" {
+			continue
+		}
+		o, ok := o.(StatefulOptimizer)
+		if !ok {
+			return fmt.Errorf("optimizer %T of group %d cannot restore the saved state", opt.Optimizers[i], i)
+		}
+		if err := o.UnmarshalState(states[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
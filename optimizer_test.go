@@ -0,0 +1,164 @@
+package goraph
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestGroupOptimizerWeightDecay(t *testing.T) {
+	decayed := NewVariable(1, 2, []float64{1, -2})
+	plain := NewVariable(1, 2, []float64{1, -2})
+	opt := NewGroupOptimizer([]ParamGroup{
+		{Parameters: []*VariableNode{decayed}, LearningRate: 0.1, WeightDecay: 0.5},
+		{Parameters: []*VariableNode{plain}, LearningRate: 0.1},
+	}, func(group ParamGroup) Optimizer {
+		return NewSGDOptimizer(group.Parameters, group.LearningRate, 0)
+	})
+	opt.Step(4)
+	for i, want := range []float64{0.95, -1.9} {
+		if math.Abs(decayed.Value.Data[i]-want) > 1e-12 {
+			t.Fatalf("decayed parameter %v, want [0.95 -1.9]", decayed.Value.Data)
+		}
+	}
+	if plain.Value.Data[0] != 1 || plain.Value.Data[1] != -2 {
+		t.Fatalf("parameter without weight decay changed to %v", plain.Value.Data)
+	}
+}
+
+/*
+stepWith sets the gradients of p, summed over a batch of two samples, and
+steps opt once for every row of grads, the mean gradients.
+*/
+func stepWith(opt Optimizer, p *VariableNode, grads ...[]float64) {
+	for _, g := range grads {
+		p.Gradient = NewMatrix(1, len(g), g).Scale(2)
+		opt.Step(2)
+	}
+}
+
+func checkValues(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Fatalf("%s: parameters %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestOptimizerUpdates(t *testing.T) {
+	grads := [][]float64{{0.5, -1}, {0.25, 0.5}}
+	cases := []struct {
+		name string
+		opt  func(p []*VariableNode) Optimizer
+		want []float64
+	}{
+		// v = 0.9v + g, p -= 0.1v: v = 0.5, 0.95 for the first element.
+		{"Momentum", func(p []*VariableNode) Optimizer {
+			return NewMomentumOptimizer(p, 0.1, 0.9)
+		}, []float64{0.88, -1.86}},
+		// p -= 0.1(g + 0.9v): 0.095 and then 0.1(0.25 + 0.9*0.7) = 0.088.
+		{"Nesterov", func(p []*VariableNode) Optimizer {
+			return NewNesterovOptimizer(p, 0.1, 0.9)
+		}, []float64{0.817, -1.824}},
+		// m = 0.9m + 0.1g, v = 0.999v + 0.001g², p -= 0.1m̂/√v̂ with the
+		// bias-corrected m̂ = m/(1-0.9^t) and v̂ = v/(1-0.999^t).
+		{"Adam", func(p []*VariableNode) Optimizer {
+			return NewAdamOptimizer(p, 0.1, 0.9, 0.999, 0)
+		}, []float64{0.8067820361188598, -1.8733662960339599}},
+		// Adam, with p -= 0.1*0.1p before every update.
+		{"AdamW", func(p []*VariableNode) Optimizer {
+			return NewAdamWOptimizer(p, 0.1, 0.9, 0.999, 0, 0.1)
+		}, []float64{0.7878820361188598, -1.83456629603396}},
+		// s = 0.9s + 0.1g², p -= 0.1g/√s.
+		{"RMSprop", func(p []*VariableNode) Optimizer {
+			return NewRMSpropOptimizer(p, 0.1, 0.9, 0)
+		}, []float64{0.5363302778282649, -1.8312141901380592}},
+		// s += g², p -= 0.1g/√s: 0.1 and then 0.025/√0.3125.
+		{"Adagrad", func(p []*VariableNode) Optimizer {
+			return NewAdagradOptimizer(p, 0.1, 0)
+		}, []float64{0.8552786404500042, -1.9447213595499957}},
+		// s = 0.9s + 0.1g², δ = √(d+ε)/√(s+ε)g, d = 0.9d + 0.1δ², p -= δ.
+		{"Adadelta", func(p []*VariableNode) Optimizer {
+			return NewAdadeltaOptimizer(p, 1, 0.9, 1e-6)
+		}, []float64{0.9947526985556963, -1.998922868013095}},
+	}
+	for _, c := range cases {
+		p := NewVariable(1, 2, []float64{1, -2})
+		stepWith(c.opt([]*VariableNode{p}), p, grads...)
+		checkValues(t, c.name, p.Value.Data, c.want)
+	}
+}
+
+func TestAdamBiasCorrection(t *testing.T) {
+	// Without bias correction the first moments are 0.1g and the second
+	// 0.001g², so the first step would be 0.1*0.1/√0.001 ≈ 0.32 instead of
+	// the learning rate.
+	for _, opt := range []func(p []*VariableNode) Optimizer{
+		func(p []*VariableNode) Optimizer { return NewAdamOptimizer(p, 0.1, 0.9, 0.999, 0) },
+		func(p []*VariableNode) Optimizer { return NewAMSGradOptimizer(p, 0.1, 0.9, 0.999, 0) },
+		func(p []*VariableNode) Optimizer { return NewAdamWOptimizer(p, 0.1, 0.9, 0.999, 0, 0) },
+	} {
+		p := NewVariable(1, 2, []float64{1, -2})
+		o := opt([]*VariableNode{p})
+		stepWith(o, p, []float64{0.5, -1})
+		checkValues(t, fmt.Sprintf("%T", o), p.Value.Data, []float64{0.9, -1.9})
+	}
+}
+
+func TestAMSGradMaximum(t *testing.T) {
+	// With Beta2 0.5 the second moment of the second element falls from 0.5
+	// to 0.375, and AMSGrad divides by the maximum, 0.5, instead.
+	grads := [][]float64{{1, -1}, {0.1, 0.5}}
+	p := NewVariable(1, 2, []float64{1, -2})
+	stepWith(NewAMSGradOptimizer([]*VariableNode{p}, 0.1, 0.9, 0.5, 0), p, grads...)
+	checkValues(t, "AMSGrad", p.Value.Data, []float64{0.835539743610969, -1.8742158974443874})
+	adam := NewVariable(1, 2, []float64{1, -2})
+	stepWith(NewAdamOptimizer([]*VariableNode{adam}, 0.1, 0.9, 0.5, 0), adam, grads...)
+	checkValues(t, "Adam", adam.Value.Data, []float64{0.8097375867671006, -1.8702270828974084})
+}
+
+func TestLAMBTrustRatio(t *testing.T) {
+	// The first Adam update is sign(g) = [1, -1], plus 0.1p = [0.3, 0.4]
+	// decay. The step is rescaled to 0.01 times the norm 5 of p.
+	p := NewVariable(1, 2, []float64{3, 4})
+	stepWith(NewLAMBOptimizer([]*VariableNode{p}, 0.01, 0.9, 0.999, 0, 0.1), p, []float64{0.5, -1})
+	checkValues(t, "LAMB", p.Value.Data, []float64{2.9546020307749776, 4.020952908873087})
+	step := math.Hypot(p.Value.Data[0]-3, p.Value.Data[1]-4)
+	if math.Abs(step-0.05) > 1e-12 {
+		t.Fatalf("LAMB step has the norm %g, want 0.01*|p| = 0.05", step)
+	}
+}
+
+func TestGroupOptimizerMomentum(t *testing.T) {
+	fast := NewVariable(1, 1, []float64{1})
+	slow := NewVariable(1, 1, []float64{1})
+	opt := NewGroupOptimizer([]ParamGroup{
+		{Parameters: []*VariableNode{fast}, LearningRate: 0.1, Momentum: 0.5},
+		{Parameters: []*VariableNode{slow}, LearningRate: 0.1, Momentum: 0.9},
+	}, func(group ParamGroup) Optimizer {
+		return NewSGDOptimizer(group.Parameters, group.LearningRate, 0)
+	})
+	for range 2 {
+		fast.Gradient = NewConstMatrix(1, 1, 1)
+		slow.Gradient = NewConstMatrix(1, 1, 1)
+		opt.Step(1)
+	}
+	// v = mv + (1-m)g and p -= 0.1v: v = 0.5, 0.75 with momentum 0.5 and
+	// 0.1, 0.19 with momentum 0.9.
+	checkValues(t, "momentum 0.5", fast.Value.Data, []float64{0.875})
+	checkValues(t, "momentum 0.9", slow.Value.Data, []float64{0.971})
+
+	adam := NewGroupOptimizer([]ParamGroup{
+		{Parameters: []*VariableNode{NewConstVariable(1, 1, 0)}, LearningRate: 0.1, Momentum: 0.8},
+		{Parameters: []*VariableNode{NewConstVariable(1, 1, 0)}, LearningRate: 0.1},
+	}, func(group ParamGroup) Optimizer {
+		return NewAdamOptimizer(group.Parameters, group.LearningRate, 0.9, 0.999, 1e-8)
+	})
+	if beta1 := adam.Optimizers[0].(*AdamOptimizer).Beta1; beta1 != 0.8 {
+		t.Fatalf("Beta1 of the group with momentum 0.8 is %g", beta1)
+	}
+	if beta1 := adam.Optimizers[1].(*AdamOptimizer).Beta1; beta1 != 0.9 {
+		t.Fatalf("Beta1 of the group without momentum is %g, want the 0.9 of the factory", beta1)
+	}
+}
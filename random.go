@@ -0,0 +1,99 @@
+package goraph
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+/*
+The library random source is used by everything stochastic in the library
+that is not given a generator of its own: dropout, the initializers when
+passed a nil *rand.Rand, and networks that are trained without a Trainer
+seed. It is seeded randomly at start-up; SetSeed makes it, and with it whole
+training runs, reproducible.
+*/
+
+/*
+lockedSource is a PCG source that is safe for concurrent use.
+*/
+type lockedSource struct {
+	mutex  sync.Mutex
+	seed   uint64
+	source *rand.PCG
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.source.Uint64()
+}
+
+func (s *lockedSource) reseed(seed uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seed = seed
+	s.source = rand.NewPCG(seed, seed)
+}
+
+var (
+	librarySource = newLockedSource(rand.Uint64())
+	libraryRand   = rand.New(librarySource)
+)
+
+func newLockedSource(seed uint64) *lockedSource {
+	s := &lockedSource{}
+	s.reseed(seed)
+	return s
+}
+
+/*
+SetSeed reseeds the library random source.
+*/
+func SetSeed(seed uint64) {
+	librarySource.reseed(seed)
+}
+
+/*
+Rand returns a generator that draws from the library random source. It is
+safe for concurrent use, but goroutines drawing from it concurrently receive
+the values in an unpredictable order; use DeriveRand to give each goroutine a
+stream of its own.
+*/
+func Rand() *rand.Rand {
+	return libraryRand
+}
+
+/*
+DeriveRand returns a new generator for the given stream. Its values depend
+only on the seed of the library random source and on stream, not on how much
+has been drawn from the library source, so concurrent work gets reproducible
+values by using one stream per unit of work.
+*/
+func DeriveRand(stream uint64) *rand.Rand {
+	librarySource.mutex.Lock()
+	seed := librarySource.seed
+	librarySource.mutex.Unlock()
+	return deriveRand(seed, stream)
+}
+
+/*
+deriveRand returns a generator whose state is derived from seed and stream.
+The stream is mixed into both words of the PCG seed, so that neighbouring
+streams are not correlated.
+*/
+func deriveRand(seed, stream uint64) *rand.Rand {
+	mixed := rand.NewPCG(seed, stream)
+	return rand.New(rand.NewPCG(mixed.Uint64(), mixed.Uint64()))
+}
+
+/*
+RandomNode is implemented by nodes that draw random numbers in Forward, such
+as DropoutNode. A NeuralNetwork gives every such node in its graphs a
+generator derived from its own random source before every forward pass, so
+that training, including the concurrent per-sample training, is reproducible
+for a given seed.
+*/
+type RandomNode interface {
+	Node
+	SetRand(r *rand.Rand)
+}
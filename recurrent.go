@@ -0,0 +1,484 @@
+package goraph
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+/*
+The recurrent cells compute a whole time step of a recurrent network in one
+node, with the gates fused into a single product with the input weights W and
+one with the recurrent weights U. X holds the inputs of the step, one sample
+per row, and the state holds the result of the previous step for the same
+samples. The gates are laid out side by side in the columns of W, U and B, in
+the order given for each cell.
+*/
+
+/*
+cellGradients returns the gradients of X·W + H·U + B with respect to X, H, W,
+U and B, given the gradients with respect to the X·W + B and the H·U terms.
+*/
+func cellGradients(gradXW, gradHU, x, h, w, u, b *Matrix) []*Matrix {
+	return []*Matrix{
+		gradXW.Multi(w.Trans()),
+		gradHU.Multi(u.Trans()),
+		x.Trans().Multi(gradXW),
+		h.Trans().Multi(gradHU),
+		gradXW.sumTo(b.Rows, b.Cols),
+	}
+}
+
+/*
+RNNCellNode defines a node that performs one step of a vanilla recurrent
+network, tanh(X·W + H·U + B), where H is the hidden state of the previous
+step.
+*/
+type RNNCellNode struct {
+	X          Node
+	H          Node
+	W          Node
+	U          Node
+	B          Node
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func RNNCell(x, h, w, u, b Node) *RNNCellNode {
+	return &RNNCellNode{
+		X: x,
+		H: h,
+		W: w,
+		U: u,
+		B: b,
+	}
+}
+
+func (m *RNNCellNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		a := m.X.Forward().Multi(m.W.Forward()).Add(m.H.Forward().Multi(m.U.Forward())).Add(m.B.Forward())
+		data := make([]float64, len(a.Data))
+		for i, v := range a.Data {
+			data[i] = math.Tanh(v)
+		}
+		m.Value = NewMatrix(a.Rows, a.Cols, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *RNNCellNode) Inputs() []Node {
+	return []Node{m.X, m.H, m.W, m.U, m.B}
+}
+func (m *RNNCellNode) Gradients(grad *Matrix) []*Matrix {
+	gradA := NewConstMatrix(grad.Rows, grad.Cols, 0)
+	for i, h := range m.Value.Data {
+		gradA.Data[i] = grad.Data[i] * (1 - h*h)
+	}
+	return cellGradients(gradA, gradA, m.X.Forward(), m.H.Forward(), m.W.Forward(), m.U.Forward(), m.B.Forward())
+}
+func (m *RNNCellNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *RNNCellNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.H.Reset()
+		m.W.Reset()
+		m.U.Reset()
+		m.B.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *RNNCellNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+GRUCellNode defines a node that performs one step of a gated recurrent unit.
+The gates are the update gate z, the reset gate r and the candidate n:
+
+	z = σ(X·Wz + H·Uz + Bz)
+	r = σ(X·Wr + H·Ur + Br)
+	n = tanh(X·Wn + r⊙(H·Un) + Bn)
+	H' = (1-z)⊙n + z⊙H
+*/
+type GRUCellNode struct {
+	X          Node
+	H          Node
+	W          Node
+	U          Node
+	B          Node
+	Value      *Matrix
+	Name       string
+	z          []float64
+	r          []float64
+	n          []float64
+	hu         *Matrix
+	valueMutex sync.Mutex
+}
+
+func GRUCell(x, h, w, u, b Node) *GRUCellNode {
+	return &GRUCellNode{
+		X: x,
+		H: h,
+		W: w,
+		U: u,
+		B: b,
+	}
+}
+
+func (m *GRUCellNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		h := m.H.Forward()
+		xw := m.X.Forward().Multi(m.W.Forward()).Add(m.B.Forward())
+		m.hu = h.Multi(m.U.Forward())
+		if xw.Cols != 3*h.Cols || xw.Rows != h.Rows {
+			panic("GRU cell dimensions do not match")
+		}
+		size := len(h.Data)
+		m.z, m.r, m.n = make([]float64, size), make([]float64, size), make([]float64, size)
+		data := make([]float64, size)
+		for i := range h.Rows {
+			for j := range h.Cols {
+				k, g := i*h.Cols+j, i*xw.Cols+j
+				m.z[k] = sigmoid(xw.Data[g] + m.hu.Data[g])
+				m.r[k] = sigmoid(xw.Data[g+h.Cols] + m.hu.Data[g+h.Cols])
+				m.n[k] = math.Tanh(xw.Data[g+2*h.Cols] + m.r[k]*m.hu.Data[g+2*h.Cols])
+				data[k] = (1-m.z[k])*m.n[k] + m.z[k]*h.Data[k]
+			}
+		}
+		m.Value = NewMatrix(h.Rows, h.Cols, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *GRUCellNode) Inputs() []Node {
+	return []Node{m.X, m.H, m.W, m.U, m.B}
+}
+func (m *GRUCellNode) Gradients(grad *Matrix) []*Matrix {
+	h := m.H.Forward()
+	gradXW := NewConstMatrix(m.hu.Rows, m.hu.Cols, 0)
+	gradHU := NewConstMatrix(m.hu.Rows, m.hu.Cols, 0)
+	gradH := NewConstMatrix(h.Rows, h.Cols, 0)
+	for i := range h.Rows {
+		for j := range h.Cols {
+			k, g := i*h.Cols+j, i*m.hu.Cols+j
+			z, r, n := m.z[k], m.r[k], m.n[k]
+			gradZ := grad.Data[k] * (h.Data[k] - n) * z * (1 - z)
+			gradN := grad.Data[k] * (1 - z) * (1 - n*n)
+			gradR := gradN * m.hu.Data[g+2*h.Cols] * r * (1 - r)
+			gradXW.Data[g], gradHU.Data[g] = gradZ, gradZ
+			gradXW.Data[g+h.Cols], gradHU.Data[g+h.Cols] = gradR, gradR
+			gradXW.Data[g+2*h.Cols], gradHU.Data[g+2*h.Cols] = gradN, gradN*r
+			gradH.Data[k] = grad.Data[k] * z
+		}
+	}
+	grads := cellGradients(gradXW, gradHU, m.X.Forward(), h, m.W.Forward(), m.U.Forward(), m.B.Forward())
+	grads[1] = grads[1].Add(gradH)
+	return grads
+}
+func (m *GRUCellNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *GRUCellNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.H.Reset()
+		m.W.Reset()
+		m.U.Reset()
+		m.B.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *GRUCellNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+LSTMCellNode defines a node that performs one step of a long short-term memory
+network. Its state S and its value hold the hidden state h and the cell state
+c side by side, [h | c], so that one node carries both to the next step; the
+hidden state is the first half of the columns. The gates are the input gate
+i, the forget gate f, the candidate g and the output gate o:
+
+	i = σ(X·Wi + h·Ui + Bi)
+	f = σ(X·Wf + h·Uf + Bf)
+	g = tanh(X·Wg + h·Ug + Bg)
+	o = σ(X·Wo + h·Uo + Bo)
+	c' = f⊙c + i⊙g
+	h' = o⊙tanh(c')
+*/
+type LSTMCellNode struct {
+	X          Node
+	S          Node
+	W          Node
+	U          Node
+	B          Node
+	Value      *Matrix
+	Name       string
+	gates      *Matrix
+	valueMutex sync.Mutex
+}
+
+func LSTMCell(x, s, w, u, b Node) *LSTMCellNode {
+	return &LSTMCellNode{
+		X: x,
+		S: s,
+		W: w,
+		U: u,
+		B: b,
+	}
+}
+
+func (m *LSTMCellNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		s := m.S.Forward()
+		size := s.Cols / 2
+		h, c := s.ColSlice(0, size), s.ColSlice(size, s.Cols)
+		a := m.X.Forward().Multi(m.W.Forward()).Add(h.Multi(m.U.Forward())).Add(m.B.Forward())
+		if s.Cols%2 != 0 || a.Cols != 4*size || a.Rows != s.Rows {
+			panic("LSTM cell dimensions do not match")
+		}
+		m.gates = NewConstMatrix(a.Rows, a.Cols, 0)
+		data := make([]float64, len(s.Data))
+		for i := range s.Rows {
+			for j := range size {
+				g := i*a.Cols + j
+				m.gates.Data[g] = sigmoid(a.Data[g])
+				m.gates.Data[g+size] = sigmoid(a.Data[g+size])
+				m.gates.Data[g+2*size] = math.Tanh(a.Data[g+2*size])
+				m.gates.Data[g+3*size] = sigmoid(a.Data[g+3*size])
+				cNext := m.gates.Data[g+size]*c.Data[i*size+j] + m.gates.Data[g]*m.gates.Data[g+2*size]
+				data[i*s.Cols+j] = m.gates.Data[g+3*size] * math.Tanh(cNext)
+				data[i*s.Cols+size+j] = cNext
+			}
+		}
+		m.Value = NewMatrix(s.Rows, s.Cols, data)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *LSTMCellNode) Inputs() []Node {
+	return []Node{m.X, m.S, m.W, m.U, m.B}
+}
+func (m *LSTMCellNode) Gradients(grad *Matrix) []*Matrix {
+	s := m.S.Forward()
+	size := s.Cols / 2
+	gradA := NewConstMatrix(m.gates.Rows, m.gates.Cols, 0)
+	gradC := NewConstMatrix(s.Rows, size, 0)
+	for i := range s.Rows {
+		for j := range size {
+			g := i*gradA.Cols + j
+			in, f, cand, o := m.gates.Data[g], m.gates.Data[g+size], m.gates.Data[g+2*size], m.gates.Data[g+3*size]
+			tc := math.Tanh(m.Value.Data[i*s.Cols+size+j])
+			gradH := grad.Data[i*s.Cols+j]
+			gradCNext := grad.Data[i*s.Cols+size+j] + gradH*o*(1-tc*tc)
+			gradA.Data[g] = gradCNext * cand * in * (1 - in)
+			gradA.Data[g+size] = gradCNext * s.Data[i*s.Cols+size+j] * f * (1 - f)
+			gradA.Data[g+2*size] = gradCNext * in * (1 - cand*cand)
+			gradA.Data[g+3*size] = gradH * tc * o * (1 - o)
+			gradC.Data[i*size+j] = gradCNext * f
+		}
+	}
+	grads := cellGradients(gradA, gradA, m.X.Forward(), s.ColSlice(0, size), m.W.Forward(), m.U.Forward(), m.B.Forward())
+	grads[1] = grads[1].HConcat(gradC)
+	return grads
+}
+func (m *LSTMCellNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *LSTMCellNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+		m.S.Reset()
+		m.W.Reset()
+		m.U.Reset()
+		m.B.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *LSTMCellNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+RecurrentCell defines the interface for the layers that Recurrent unrolls. A
+layer holds the parameters shared by all time steps; Step builds the node of
+one step from the input of the step and the state of the previous one, and
+returns the new state along with the hidden output of the step.
+*/
+type RecurrentCell interface {
+	InitialState(batchSize int) Node
+	Step(x, state Node) (next, hidden Node)
+	Parameters() []*VariableNode
+}
+
+/*
+recurrentVariables creates the weights of a recurrent layer with the given
+number of gates: W and U drawn with Xavier initialization per gate, and B
+initialized to zeros.
+*/
+func recurrentVariables(inputSize, hiddenSize, gates int, r *rand.Rand) (w, u, b *VariableNode) {
+	w = NewRandomVariable(inputSize, gates*hiddenSize, NewXavierUniformInitWithRand(inputSize, hiddenSize, r))
+	u = NewRandomVariable(hiddenSize, gates*hiddenSize, NewXavierUniformInitWithRand(hiddenSize, hiddenSize, r))
+	b = NewConstVariable(1, gates*hiddenSize, 0)
+	return
+}
+
+/*
+zeroState returns a frozen variable of zeros, used as the state before the
+first step.
+*/
+func zeroState(rows, cols int) Node {
+	state := NewConstVariable(rows, cols, 0)
+	state.Freeze()
+	return state
+}
+
+/*
+RNNLayer holds the parameters of a vanilla recurrent layer for RNNCell.
+*/
+type RNNLayer struct {
+	W *VariableNode
+	U *VariableNode
+	B *VariableNode
+}
+
+func NewRNNLayer(inputSize, hiddenSize int, r *rand.Rand) *RNNLayer {
+	w, u, b := recurrentVariables(inputSize, hiddenSize, 1, r)
+	return &RNNLayer{W: w, U: u, B: b}
+}
+
+func (l *RNNLayer) InitialState(batchSize int) Node {
+	return zeroState(batchSize, l.U.Value.Rows)
+}
+func (l *RNNLayer) Step(x, state Node) (next, hidden Node) {
+	next = RNNCell(x, state, l.W, l.U, l.B)
+	return next, next
+}
+func (l *RNNLayer) Parameters() []*VariableNode {
+	return []*VariableNode{l.W, l.U, l.B}
+}
+
+/*
+GRULayer holds the parameters of a gated recurrent unit layer for GRUCell.
+*/
+type GRULayer struct {
+	W *VariableNode
+	U *VariableNode
+	B *VariableNode
+}
+
+func NewGRULayer(inputSize, hiddenSize int, r *rand.Rand) *GRULayer {
+	w, u, b := recurrentVariables(inputSize, hiddenSize, 3, r)
+	return &GRULayer{W: w, U: u, B: b}
+}
+
+func (l *GRULayer) InitialState(batchSize int) Node {
+	return zeroState(batchSize, l.U.Value.Rows)
+}
+func (l *GRULayer) Step(x, state Node) (next, hidden Node) {
+	next = GRUCell(x, state, l.W, l.U, l.B)
+	return next, next
+}
+func (l *GRULayer) Parameters() []*VariableNode {
+	return []*VariableNode{l.W, l.U, l.B}
+}
+
+/*
+LSTMLayer holds the parameters of a long short-term memory layer for
+LSTMCell. The bias of the forget gate starts at 1, so that the layer keeps
+its cell state until it learns otherwise.
+*/
+type LSTMLayer struct {
+	W *VariableNode
+	U *VariableNode
+	B *VariableNode
+}
+
+func NewLSTMLayer(inputSize, hiddenSize int, r *rand.Rand) *LSTMLayer {
+	w, u, b := recurrentVariables(inputSize, hiddenSize, 4, r)
+	for j := hiddenSize; j < 2*hiddenSize; j++ {
+		b.Value.Data[j] = 1
+	}
+	return &LSTMLayer{W: w, U: u, B: b}
+}
+
+func (l *LSTMLayer) InitialState(batchSize int) Node {
+	return zeroState(batchSize, 2*l.U.Value.Rows)
+}
+func (l *LSTMLayer) Step(x, state Node) (next, hidden Node) {
+	next = LSTMCell(x, state, l.W, l.U, l.B)
+	return next, ColSlice(next, 0, l.U.Value.Rows)
+}
+func (l *LSTMLayer) Parameters() []*VariableNode {
+	return []*VariableNode{l.W, l.U, l.B}
+}
+
+/*
+Recurrent unrolls cell over the rows of x, which hold steps time steps of
+batchSize samples each: rows t*batchSize to (t+1)*batchSize-1 are the inputs
+of step t. With a batch size of 1, every row is one time step.
+
+If reverse is not nil, the network is bidirectional: reverse runs over the
+steps from last to first, and the hidden outputs of both directions are
+concatenated side by side, forward first. With returnSequences, the result
+holds the hidden outputs of all steps, in the same layout as x; otherwise it
+holds only the last hidden output of each direction, one row per sample.
+
+Recurrent panics if cell is nil, if steps or batchSize is not positive, or, if
+x is a variable, such as the input of a network, if it does not have exactly
+steps*batchSize rows. The rows of other nodes are only known once they are
+computed, so a shorter x then panics in the forward pass of a step.
+*/
+func Recurrent(x Node, cell, reverse RecurrentCell, steps, batchSize int, returnSequences bool) Node {
+	if cell == nil {
+		panic("Recurrent needs a cell")
+	}
+	if steps < 1 || batchSize < 1 {
+		panic("Number of steps and batch size must be positive")
+	}
+	if v, ok := x.(*VariableNode); ok && v.Value.Rows != steps*batchSize {
+		panic(fmt.Sprintf("Recurrent input has %d rows, expected %d steps of %d samples", v.Value.Rows, steps, batchSize))
+	}
+	unroll := func(cell RecurrentCell, order func(int) int) []Node {
+		hidden := make([]Node, steps)
+		state := cell.InitialState(batchSize)
+		for i := range steps {
+			t := order(i)
+			state, hidden[t] = cell.Step(RowSlice(x, t*batchSize, (t+1)*batchSize), state)
+		}
+		return hidden
+	}
+	outputs := unroll(cell, func(i int) int { return i })
+	last := outputs[steps-1]
+	if reverse != nil {
+		reversed := unroll(reverse, func(i int) int { return steps - 1 - i })
+		last = HConcat(last, reversed[0])
+		for t := range outputs {
+			outputs[t] = HConcat(outputs[t], reversed[t])
+		}
+	}
+	if !returnSequences {
+		return last
+	}
+	result := outputs[0]
+	for _, output := range outputs[1:] {
+		result = VConcat(result, output)
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package goraph
+
+import "testing"
+
+func TestRecurrentCellGradients(t *testing.T) {
+	r := testRand()
+	x, h := randomVariable(r, 3, 4), randomVariable(r, 3, 5)
+	t.Run("RNN", func(t *testing.T) {
+		w, u, b := randomVariable(r, 4, 5), randomVariable(r, 5, 5), randomVariable(r, 1, 5)
+		checkGradients(t, project(r, RNNCell(x, h, w, u, b), 3, 5), x, h, w, u, b)
+	})
+	t.Run("GRU", func(t *testing.T) {
+		w, u, b := randomVariable(r, 4, 15), randomVariable(r, 5, 15), randomVariable(r, 1, 15)
+		checkGradients(t, project(r, GRUCell(x, h, w, u, b), 3, 5), x, h, w, u, b)
+	})
+	t.Run("LSTM", func(t *testing.T) {
+		s := randomVariable(r, 3, 10)
+		w, u, b := randomVariable(r, 4, 20), randomVariable(r, 5, 20), randomVariable(r, 1, 20)
+		checkGradients(t, project(r, LSTMCell(x, s, w, u, b), 3, 10), x, s, w, u, b)
+	})
+}
+
+func TestRecurrentGradients(t *testing.T) {
+	layers := map[string]func() RecurrentCell{
+		"RNN":  func() RecurrentCell { return NewRNNLayer(4, 3, testRand()) },
+		"GRU":  func() RecurrentCell { return NewGRULayer(4, 3, testRand()) },
+		"LSTM": func() RecurrentCell { return NewLSTMLayer(4, 3, testRand()) },
+	}
+	for name, layer := range layers {
+		t.Run(name, func(t *testing.T) {
+			r := testRand()
+			x := randomVariable(r, 6*2, 4)
+			forward, reverse := layer(), layer()
+			params := append(append([]*VariableNode{x}, forward.Parameters()...), reverse.Parameters()...)
+			checkGradients(t, project(r, Recurrent(x, forward, nil, 6, 2, false), 2, 3), params[:4]...)
+			checkGradients(t, project(r, Recurrent(x, forward, reverse, 6, 2, false), 2, 6), params...)
+			checkGradients(t, project(r, Recurrent(x, forward, reverse, 6, 2, true), 12, 6), params...)
+		})
+	}
+}
+
+func TestRecurrentArguments(t *testing.T) {
+	cases := map[string]func(){
+		"nil cell":       func() { Recurrent(NewConstVariable(4, 2, 0), nil, nil, 2, 2, false) },
+		"zero steps":     func() { Recurrent(NewConstVariable(4, 2, 0), NewRNNLayer(2, 3, testRand()), nil, 0, 2, false) },
+		"zero batch":     func() { Recurrent(NewConstVariable(4, 2, 0), NewRNNLayer(2, 3, testRand()), nil, 2, 0, false) },
+		"short sequence": func() { Recurrent(NewConstVariable(3, 2, 0), NewRNNLayer(2, 3, testRand()), nil, 2, 2, false) },
+	}
+	for name, build := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("invalid arguments did not panic")
+				}
+			}()
+			build()
+		})
+	}
+}
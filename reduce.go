@@ -0,0 +1,707 @@
+package goraph
+
+import (
+	"math"
+	"sync"
+)
+
+/*
+The reduction nodes reduce X along Axis: every row for RowAxis, giving a
+column vector like RowSum, every column for ColAxis, giving a row vector like
+ColSum, or the whole matrix for AllAxis, giving a 1x1 matrix. Mean, Max, Min,
+Variance, Std and LogSumExp are undefined on a lane without values and panic
+on one; L1Norm and L2Norm give 0 for it and Product 1.
+*/
+
+/*
+laneValues returns the values of x in every lane along axis.
+*/
+func laneValues(x *Matrix, lanes [][]int) [][]float64 {
+	values := make([][]float64, len(lanes))
+	for l, lane := range lanes {
+		values[l] = make([]float64, len(lane))
+		for k, idx := range lane {
+			values[l][k] = x.Data[idx]
+		}
+	}
+	return values
+}
+
+/*
+reduceLanes returns the result f of every lane of x along axis, shaped as
+described above.
+*/
+func reduceLanes(x *Matrix, axis Axis, f func(values []float64) float64) *Matrix {
+	values := laneValues(x, x.lanes(axis))
+	data := make([]float64, len(values))
+	for l := range values {
+		data[l] = f(values[l])
+	}
+	switch axis {
+	case RowAxis:
+		return NewMatrix(x.Rows, 1, data)
+	case ColAxis:
+		return NewMatrix(1, x.Cols, data)
+	default:
+		return NewMatrix(1, 1, data)
+	}
+}
+
+/*
+laneGradients returns the gradient with respect to x of a reduction along
+axis with the value y, given the partial derivatives df of the result of a
+lane with respect to each of its values.
+*/
+func laneGradients(grad, x, y *Matrix, axis Axis, df func(values []float64, y float64) []float64) *Matrix {
+	lanes := x.lanes(axis)
+	values := laneValues(x, lanes)
+	gradX := NewConstMatrix(x.Rows, x.Cols, 0)
+	for l, lane := range lanes {
+		partials := df(values[l], y.Data[l])
+		for k, idx := range lane {
+			gradX.Data[idx] = grad.Data[l] * partials[k]
+		}
+	}
+	return gradX
+}
+
+/*
+nonEmpty panics if a lane has no values.
+*/
+func nonEmpty(values []float64) {
+	if len(values) == 0 {
+		panic("Cannot reduce an empty lane")
+	}
+}
+
+/*
+constPartials returns partial derivatives that are all equal to v.
+*/
+func constPartials(n int, v float64) []float64 {
+	partials := make([]float64, n)
+	for k := range partials {
+		partials[k] = v
+	}
+	return partials
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func variance(values []float64) float64 {
+	mu := mean(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mu) * (v - mu)
+	}
+	return sum / float64(len(values))
+}
+
+/*
+Sum adds up the values along axis with RowSum, ColSum or, for AllAxis, both.
+*/
+func Sum(x Node, axis Axis) Node {
+	switch axis {
+	case RowAxis:
+		return RowSum(x)
+	case ColAxis:
+		return ColSum(x)
+	case AllAxis:
+		return ColSum(RowSum(x))
+	default:
+		panic("Invalid axis")
+	}
+}
+
+/*
+MeanNode defines a node that computes the mean of every lane.
+*/
+type MeanNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Mean(x Node, axis Axis) *MeanNode {
+	return &MeanNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *MeanNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	return mean(values)
+}
+
+func (m *MeanNode) partials(values []float64, y float64) []float64 {
+	return constPartials(len(values), 1/float64(len(values)))
+}
+
+func (m *MeanNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *MeanNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *MeanNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *MeanNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *MeanNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *MeanNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+MaxNode defines a node that takes the largest value of every lane. The
+gradient goes to the position of the largest value, the first one if several
+are equal.
+*/
+type MaxNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Max(x Node, axis Axis) *MaxNode {
+	return &MaxNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *MaxNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	return values[argMax(values)]
+}
+
+func (m *MaxNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	partials[argMax(values)] = 1
+	return partials
+}
+
+func (m *MaxNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *MaxNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *MaxNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *MaxNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *MaxNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *MaxNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+MinNode defines a node that takes the smallest value of every lane. The
+gradient goes to the position of the smallest value, the first one if
+several are equal.
+*/
+type MinNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Min(x Node, axis Axis) *MinNode {
+	return &MinNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *MinNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	return values[argMin(values)]
+}
+
+func (m *MinNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	partials[argMin(values)] = 1
+	return partials
+}
+
+func (m *MinNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *MinNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *MinNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *MinNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *MinNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *MinNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+VarianceNode defines a node that computes the biased variance of every lane,
+the mean squared deviation from the mean of the lane.
+*/
+type VarianceNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Variance(x Node, axis Axis) *VarianceNode {
+	return &VarianceNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *VarianceNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	return variance(values)
+}
+
+func (m *VarianceNode) partials(values []float64, y float64) []float64 {
+	mu, n := mean(values), float64(len(values))
+	partials := make([]float64, len(values))
+	for k, v := range values {
+		partials[k] = 2 * (v - mu) / n
+	}
+	return partials
+}
+
+func (m *VarianceNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *VarianceNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *VarianceNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *VarianceNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *VarianceNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *VarianceNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+StdNode defines a node that computes the square root of the biased variance
+of every lane. A lane of equal values has no gradient.
+*/
+type StdNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Std(x Node, axis Axis) *StdNode {
+	return &StdNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *StdNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	return math.Sqrt(variance(values))
+}
+
+func (m *StdNode) partials(values []float64, y float64) []float64 {
+	mu, n := mean(values), float64(len(values))
+	partials := make([]float64, len(values))
+	if y == 0 {
+		return partials
+	}
+	for k, v := range values {
+		partials[k] = (v - mu) / (n * y)
+	}
+	return partials
+}
+
+func (m *StdNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *StdNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *StdNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *StdNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *StdNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *StdNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+LogSumExpNode defines a node that computes log(Σe^x) over every lane without
+overflowing; its gradient is the softmax of the lane.
+*/
+type LogSumExpNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func LogSumExp(x Node, axis Axis) *LogSumExpNode {
+	return &LogSumExpNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *LogSumExpNode) reduce(values []float64) float64 {
+	nonEmpty(values)
+	maxVal := values[argMax(values)]
+	if math.IsInf(maxVal, 0) {
+		return maxVal
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += math.Exp(v - maxVal)
+	}
+	return maxVal + math.Log(sum)
+}
+
+func (m *LogSumExpNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	for k, v := range values {
+		partials[k] = definedOrZero(math.Exp(v - y))
+	}
+	return partials
+}
+
+func (m *LogSumExpNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *LogSumExpNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *LogSumExpNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *LogSumExpNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *LogSumExpNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *LogSumExpNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+L1NormNode defines a node that computes the sum of the absolute values of
+every lane, with a gradient of 0 for values of 0.
+*/
+type L1NormNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func L1Norm(x Node, axis Axis) *L1NormNode {
+	return &L1NormNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *L1NormNode) reduce(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += math.Abs(v)
+	}
+	return sum
+}
+
+func (m *L1NormNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	for k, v := range values {
+		if v > 0 {
+			partials[k] = 1
+		} else if v < 0 {
+			partials[k] = -1
+		}
+	}
+	return partials
+}
+
+func (m *L1NormNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *L1NormNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *L1NormNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *L1NormNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *L1NormNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *L1NormNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+L2NormNode defines a node that computes the Euclidean norm of every lane. A
+lane of zeros has no gradient.
+*/
+type L2NormNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func L2Norm(x Node, axis Axis) *L2NormNode {
+	return &L2NormNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *L2NormNode) reduce(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v * v
+	}
+	return math.Sqrt(sum)
+}
+
+func (m *L2NormNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	if y == 0 {
+		return partials
+	}
+	for k, v := range values {
+		partials[k] = v / y
+	}
+	return partials
+}
+
+func (m *L2NormNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *L2NormNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *L2NormNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *L2NormNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *L2NormNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *L2NormNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+ProductNode defines a node that multiplies the values of every lane. The
+partial derivative for a value is the product of the other values, computed
+without dividing, so lanes containing zeros get their exact gradient.
+*/
+type ProductNode struct {
+	X          Node
+	Axis       Axis
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func Product(x Node, axis Axis) *ProductNode {
+	return &ProductNode{
+		X:    x,
+		Axis: axis,
+	}
+}
+
+func (m *ProductNode) reduce(values []float64) float64 {
+	product := 1.0
+	for _, v := range values {
+		product *= v
+	}
+	return product
+}
+
+func (m *ProductNode) partials(values []float64, y float64) []float64 {
+	partials := make([]float64, len(values))
+	prefix := 1.0
+	for k, v := range values {
+		partials[k] = prefix
+		prefix *= v
+	}
+	suffix := 1.0
+	for k := len(values) - 1; k >= 0; k-- {
+		partials[k] *= suffix
+		suffix *= values[k]
+	}
+	return partials
+}
+
+func (m *ProductNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		m.Value = reduceLanes(m.X.Forward(), m.Axis, m.reduce)
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+func (m *ProductNode) Inputs() []Node {
+	return []Node{m.X}
+}
+func (m *ProductNode) Gradients(grad *Matrix) []*Matrix {
+	return []*Matrix{laneGradients(grad, m.X.Forward(), m.Forward(), m.Axis, m.partials)}
+}
+func (m *ProductNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *ProductNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		m.X.Reset()
+	}
+	m.valueMutex.Unlock()
+}
+func (m *ProductNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
@@ -0,0 +1,110 @@
+package goraph
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReduceGradients(t *testing.T) {
+	reductions := map[string]func(x Node, axis Axis) Node{
+		"Sum":       Sum,
+		"Mean":      func(x Node, axis Axis) Node { return Mean(x, axis) },
+		"Max":       func(x Node, axis Axis) Node { return Max(x, axis) },
+		"Min":       func(x Node, axis Axis) Node { return Min(x, axis) },
+		"Variance":  func(x Node, axis Axis) Node { return Variance(x, axis) },
+		"Std":       func(x Node, axis Axis) Node { return Std(x, axis) },
+		"LogSumExp": func(x Node, axis Axis) Node { return LogSumExp(x, axis) },
+		"L1Norm":    func(x Node, axis Axis) Node { return L1Norm(x, axis) },
+		"L2Norm":    func(x Node, axis Axis) Node { return L2Norm(x, axis) },
+		"Product":   func(x Node, axis Axis) Node { return Product(x, axis) },
+	}
+	shapes := map[Axis][2]int{RowAxis: {3, 1}, ColAxis: {1, 4}, AllAxis: {1, 1}}
+	for name, reduction := range reductions {
+		for axis, shape := range shapes {
+			t.Run(name, func(t *testing.T) {
+				r := testRand()
+				x := randomVariable(r, 3, 4)
+				checkGradients(t, project(r, reduction(x, axis), shape[0], shape[1]), x)
+			})
+		}
+	}
+}
+
+func TestProductWithZeros(t *testing.T) {
+	r := testRand()
+	x := randomVariable(r, 3, 4)
+	x.Value.Data[1] = 0
+	x.Value.Data[4], x.Value.Data[6] = 0, 0
+	checkGradients(t, project(r, Product(x, RowAxis), 3, 1), x)
+	checkGradients(t, project(r, Product(x, ColAxis), 1, 4), x)
+}
+
+func TestMaxMinTies(t *testing.T) {
+	x := NewVariable(2, 3, []float64{1, 3, 3, 2, 0, 0})
+	Max(x, RowAxis).Backward(NewConstMatrix(2, 1, 1))
+	if want := []float64{0, 1, 0, 1, 0, 0}; !slices.Equal(x.Gradient.Data, want) {
+		t.Fatalf("Max gradient %v, want the first largest value %v", x.Gradient.Data, want)
+	}
+	x.Reset()
+	Min(x, RowAxis).Backward(NewConstMatrix(2, 1, 1))
+	if want := []float64{1, 0, 0, 0, 1, 0}; !slices.Equal(x.Gradient.Data, want) {
+		t.Fatalf("Min gradient %v, want the first smallest value %v", x.Gradient.Data, want)
+	}
+}
+
+func TestReduceConstantLanes(t *testing.T) {
+	x := NewVariable(1, 3, []float64{0, 0, 0})
+	for name, reduction := range map[string]func(x Node, axis Axis) Node{
+		"Std":    func(x Node, axis Axis) Node { return Std(x, axis) },
+		"L2Norm": func(x Node, axis Axis) Node { return L2Norm(x, axis) },
+		"L1Norm": func(x Node, axis Axis) Node { return L1Norm(x, axis) },
+	} {
+		x.Reset()
+		reduction(x, RowAxis).Backward(NewConstMatrix(1, 1, 1))
+		if !slices.Equal(x.Gradient.Data, []float64{0, 0, 0}) {
+			t.Errorf("%s gradient of a lane of zeros %v, want zeros", name, x.Gradient.Data)
+		}
+	}
+}
+
+func TestReduceEmptyLanes(t *testing.T) {
+	x := NewVariable(2, 0, nil)
+	if got := Product(x, RowAxis).Forward().Data; !slices.Equal(got, []float64{1, 1}) {
+		t.Errorf("Product of empty lanes %v, want ones", got)
+	}
+	if got := L2Norm(x, RowAxis).Forward().Data; !slices.Equal(got, []float64{0, 0}) {
+		t.Errorf("L2Norm of empty lanes %v, want zeros", got)
+	}
+	for name, reduction := range map[string]func(x Node, axis Axis) Node{
+		"Mean":      func(x Node, axis Axis) Node { return Mean(x, axis) },
+		"Max":       func(x Node, axis Axis) Node { return Max(x, axis) },
+		"Min":       func(x Node, axis Axis) Node { return Min(x, axis) },
+		"Std":       func(x Node, axis Axis) Node { return Std(x, axis) },
+		"LogSumExp": func(x Node, axis Axis) Node { return LogSumExp(x, axis) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of empty lanes did not panic", name)
+				}
+			}()
+			reduction(x, RowAxis).Forward()
+		}()
+	}
+}
+
+func TestTopK(t *testing.T) {
+	m := NewMatrix(2, 3, []float64{1, 3, 2, 5, 5, 4})
+	if got := m.TopK(2, RowAxis); !slices.Equal(got[0], []int{1, 2}) || !slices.Equal(got[1], []int{0, 1}) {
+		t.Fatalf("TopK %v", got)
+	}
+	if got := m.TopK(5, ColAxis); len(got[0]) != 2 {
+		t.Fatalf("TopK of a short lane %v, want all positions", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("negative k did not panic")
+		}
+	}()
+	m.TopK(-1, RowAxis)
+}
@@ -0,0 +1,161 @@
+package goraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+typeRegistry maps type names to factories, so values stored behind an
+interface can be encoded together with the name of their concrete type and
+decoded back into that type.
+*/
+type typeRegistry struct {
+	mutex     sync.RWMutex
+	factories map[string]func() any
+	names     map[reflect.Type]string
+}
+
+var (
+	scalerRegistry     = &typeRegistry{}
+	normalizerRegistry = &typeRegistry{}
+)
+
+func init() {
+	RegisterScaler("MinMaxScaler", func() Scaler { return &MinMaxScaler{} })
+	RegisterScaler("RobustScaler", func() Scaler { return &RobustScaler{} })
+	RegisterScaler("ZScoreScaler", func() Scaler { return &ZScoreScaler{} })
+	RegisterNormalizer("L2Normalizer", func() Normalizer { return &L2Normalizer{} })
+}
+
+/*
+RegisterScaler registers a Scaler implementation under a unique name, so that
+a Model holding it can be saved and loaded. The factory must return a new
+value of the same concrete type every time, usually a pointer to a zero
+struct, which the saved JSON is decoded into.
+*/
+func RegisterScaler(name string, factory func() Scaler) {
+	scalerRegistry.register(name, func() any { return factory() })
+}
+
+/*
+RegisterNormalizer registers a Normalizer implementation under a unique name,
+in the same way as RegisterScaler.
+*/
+func RegisterNormalizer(name string, factory func() Normalizer) {
+	normalizerRegistry.register(name, func() any { return factory() })
+}
+
+func (r *typeRegistry) register(name string, factory func() any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.factories == nil {
+		r.factories = make(map[string]func() any)
+		r.names = make(map[reflect.Type]string)
+	}
+	if _, ok := r.factories[name]; ok {
+		panic(fmt.Sprintf("Type name %q is already registered", name))
+	}
+	r.factories[name] = factory
+	r.names[reflect.TypeOf(factory())] = name
+}
+
+/*
+typedValue is the encoded form of a registered value.
+*/
+type typedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (r *typeRegistry) encode(value any) (json.RawMessage, error) {
+	if value == nil {
+		return json.RawMessage("null"), nil
+	}
+	r.mutex.RLock()
+	name, ok := r.names[reflect.TypeOf(value)]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %T is not registered", value)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(typedValue{Type: name, Value: data})
+}
+
+/*
+decode decodes an encoded value. If current is not nil and of the encoded
+type, the value is decoded into it, otherwise a new value is created by the
+registered factory. Data without a type name, as written before types were
+recorded, can only be decoded into an existing value.
+*/
+func (r *typeRegistry) decode(data json.RawMessage, current any) (any, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+	var typed typedValue
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	if typed.Type == "" {
+		if current == nil {
+			return nil, fmt.Errorf("cannot decode a value without type name")
+		}
+		return current, json.Unmarshal(data, current)
+	}
+	r.mutex.RLock()
+	factory, ok := r.factories[typed.Type]
+	currentName := ""
+	if current != nil {
+		currentName = r.names[reflect.TypeOf(current)]
+	}
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %q is not registered", typed.Type)
+	}
+	value := current
+	if currentName != typed.Type {
+		value = factory()
+	}
+	return value, json.Unmarshal(typed.Value, value)
+}
+
+func encodeSlice[T any](r *typeRegistry, values []T) ([]json.RawMessage, error) {
+	if values == nil {
+		return nil, nil
+	}
+	result := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		data, err := r.encode(value)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = data
+	}
+	return result, nil
+}
+
+func decodeSlice[T any](r *typeRegistry, data []json.RawMessage, current []T) ([]T, error) {
+	if data == nil {
+		return nil, nil
+	}
+	result := make([]T, len(data))
+	for i := range data {
+		var currentValue any
+		if i < len(current) {
+			currentValue = current[i]
+		}
+		value, err := r.decode(data[i], currentValue)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			result[i] = value.(T)
+		}
+	}
+	return result, nil
+}
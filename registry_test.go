@@ -0,0 +1,103 @@
+package goraph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+offsetScaler is a user-defined scaler, registered by the tests, that
+subtracts the mean of every column.
+*/
+type offsetScaler struct {
+	Offsets []float64 `json:"offsets"`
+}
+
+func (s *offsetScaler) Fit(data [][]float64) {
+	s.Offsets = make([]float64, len(data[0]))
+	for _, item := range data {
+		for j, v := range item {
+			s.Offsets[j] += v / float64(len(data))
+		}
+	}
+}
+
+func (s *offsetScaler) Transform(data [][]float64) [][]float64 {
+	result := make([][]float64, len(data))
+	for i, item := range data {
+		result[i] = make([]float64, len(item))
+		for j, v := range item {
+			result[i][j] = v - s.Offsets[j]
+		}
+	}
+	return result
+}
+
+/*
+unregisteredScaler is a scaler that is never registered.
+*/
+type unregisteredScaler struct {
+	offsetScaler
+}
+
+func init() {
+	RegisterScaler("goraph.offsetScaler", func() Scaler { return &offsetScaler{} })
+}
+
+func TestModelScalersRoundTrip(t *testing.T) {
+	data := [][]float64{{1, 4, -2, 3}, {2, 0, 5, 1}, {-3, 2, 1, 6}, {0, 7, 2, -1}}
+	targets := [][]float64{{10}, {-4}, {3}, {8}}
+	w := NewVariable(1, 2, []float64{0.5, -1})
+	model := NewModel([]*VariableNode{w}, []Scaler{
+		NewMinMaxScaler(4, [][]int{{0, 1}, {2}}),
+		NewZScoreScaler(4, [][]int{{0}, {1, 2, 3}}),
+		&offsetScaler{},
+	}, []Scaler{NewRobustScaler(1, [][]int{{0}})})
+	model.InputNormalizers = []Normalizer{NewL2Normalizer(4, [][]int{{0, 1, 2, 3}})}
+	for _, scaler := range model.InputScalers {
+		scaler.Fit(data)
+		data = scaler.Transform(data)
+	}
+	model.TargetScalers[0].Fit(targets)
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := model.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A model without scalers gets them from the registered factories.
+	loaded := NewModel([]*VariableNode{NewConstVariable(1, 2, 0)}, nil, nil)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.InputScalers, model.InputScalers) ||
+		!reflect.DeepEqual(loaded.TargetScalers, model.TargetScalers) ||
+		!reflect.DeepEqual(loaded.InputNormalizers, model.InputNormalizers) {
+		t.Fatalf("loaded scalers and normalizers differ from the saved ones")
+	}
+	raw := [][]float64{{1, 2, 3, 4}}
+	if !reflect.DeepEqual(loaded.TransformInputs(raw), model.TransformInputs(raw)) {
+		t.Fatalf("loaded model transforms inputs differently")
+	}
+	if !reflect.DeepEqual(loaded.Parameters[0].Value.Data, w.Value.Data) {
+		t.Fatalf("loaded parameters %v, want %v", loaded.Parameters[0].Value.Data, w.Value.Data)
+	}
+}
+
+func TestModelUnregisteredScaler(t *testing.T) {
+	dir := t.TempDir()
+	model := NewModel(nil, []Scaler{&unregisteredScaler{}}, nil)
+	if err := model.Save(filepath.Join(dir, "unregistered.json")); err == nil {
+		t.Fatal("saving an unregistered scaler did not return an error")
+	}
+
+	path := filepath.Join(dir, "unknown.json")
+	data := `{"parameters":[],"input_scalers":[{"type":"UnknownScaler","value":{}}],"target_scalers":null}`
+	if err := os.WriteFile(path, []byte(data), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewModel(nil, nil, nil).Load(path); err == nil {
+		t.Fatal("loading an unknown scaler type did not return an error")
+	}
+}
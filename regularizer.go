@@ -0,0 +1,155 @@
+package goraph
+
+import (
+	"math"
+	"sync"
+)
+
+/*
+RegularizerNode defines a node that penalizes the magnitude of parameters. Its
+value is the 1x1 matrix L1*Σ|w| + L2/2*Σw² over every element w of every
+parameter, and it is meant to be added to a loss, for example
+Add(MSELoss(output, target), L2Regularizer(parameters, 1e-4)). The gradient
+with respect to a parameter is L1*sign(w) + L2*w, so the L2 term acts as weight
+decay.
+*/
+type RegularizerNode struct {
+	Parameters []*VariableNode
+	L1         float64
+	L2         float64
+	Value      *Matrix
+	Name       string
+	valueMutex sync.Mutex
+}
+
+func L1Regularizer(parameters []*VariableNode, lambda float64) *RegularizerNode {
+	return &RegularizerNode{
+		Parameters: parameters,
+		L1:         lambda,
+	}
+}
+
+func L2Regularizer(parameters []*VariableNode, lambda float64) *RegularizerNode {
+	return &RegularizerNode{
+		Parameters: parameters,
+		L2:         lambda,
+	}
+}
+
+/*
+ElasticNetRegularizer combines an L1 and an L2 penalty.
+*/
+func ElasticNetRegularizer(parameters []*VariableNode, l1, l2 float64) *RegularizerNode {
+	return &RegularizerNode{
+		Parameters: parameters,
+		L1:         l1,
+		L2:         l2,
+	}
+}
+
+func (m *RegularizerNode) Forward() *Matrix {
+	m.valueMutex.Lock()
+	if m.Value == nil {
+		sum := 0.0
+		for _, p := range m.Parameters {
+			for _, w := range p.Forward().Data {
+				sum += m.L1*math.Abs(w) + m.L2/2*w*w
+			}
+		}
+		m.Value = NewMatrix(1, 1, []float64{sum})
+	}
+	m.valueMutex.Unlock()
+	return m.Value
+}
+
+func (m *RegularizerNode) Inputs() []Node {
+	inputs := make([]Node, len(m.Parameters))
+	for i, p := range m.Parameters {
+		inputs[i] = p
+	}
+	return inputs
+}
+func (m *RegularizerNode) Gradients(grad *Matrix) []*Matrix {
+	grads := make([]*Matrix, len(m.Parameters))
+	for i, p := range m.Parameters {
+		value := p.Forward()
+		data := make([]float64, len(value.Data))
+		for j, w := range value.Data {
+			sign := 0.0
+			if w > 0 {
+				sign = 1
+			} else if w < 0 {
+				sign = -1
+			}
+			data[j] = grad.Data[0] * (m.L1*sign + m.L2*w)
+		}
+		grads[i] = NewMatrix(value.Rows, value.Cols, data)
+	}
+	return grads
+}
+func (m *RegularizerNode) Backward(grad *Matrix) {
+	backward(m, grad)
+}
+func (m *RegularizerNode) Reset() {
+	m.valueMutex.Lock()
+	if m.Value != nil {
+		m.Value = nil
+		for _, p := range m.Parameters {
+			p.Reset()
+		}
+	}
+	m.valueMutex.Unlock()
+}
+func (m *RegularizerNode) Tag(name string) Node {
+	m.Name = name
+	return m
+}
+
+/*
+Constraint defines the interface for constraints on parameters, which are
+applied after every optimization step, for example through the Constraints of
+a NeuralNetwork.
+*/
+type Constraint interface {
+	Apply()
+}
+
+/*
+MaxNormConstraint rescales every lane of the parameters along Axis whose L2
+norm exceeds MaxNorm down to MaxNorm. For a weight matrix that maps the
+columns of its input to its own columns, ColAxis limits the norm of the
+incoming weights of every unit. Frozen parameters are left unchanged.
+*/
+type MaxNormConstraint struct {
+	Parameters []*VariableNode
+	MaxNorm    float64
+	Axis       Axis
+}
+
+func NewMaxNormConstraint(parameters []*VariableNode, maxNorm float64, axis Axis) *MaxNormConstraint {
+	return &MaxNormConstraint{
+		Parameters: parameters,
+		MaxNorm:    maxNorm,
+		Axis:       axis,
+	}
+}
+
+func (c *MaxNormConstraint) Apply() {
+	for _, p := range c.Parameters {
+		if p.Frozen {
+			continue
+		}
+		for _, lane := range p.Value.lanes(c.Axis) {
+			sum := 0.0
+			for _, idx := range lane {
+				sum += p.Value.Data[idx] * p.Value.Data[idx]
+			}
+			norm := math.Sqrt(sum)
+			if norm > c.MaxNorm {
+				for _, idx := range lane {
+					p.Value.Data[idx] *= c.MaxNorm / norm
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package goraph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRegularizerValue(t *testing.T) {
+	a := NewVariable(1, 2, []float64{1, -2})
+	b := NewVariable(1, 1, []float64{3})
+	// L1 = 0.1*(1+2+3) and L2 = 0.5/2*(1+4+9).
+	value := ElasticNetRegularizer([]*VariableNode{a, b}, 0.1, 0.5).Forward().Data[0]
+	if math.Abs(value-4.1) > 1e-12 {
+		t.Fatalf("penalty %g, want 0.6 + 3.5", value)
+	}
+}
+
+func TestRegularizerGradients(t *testing.T) {
+	r := testRand()
+	for _, c := range []struct {
+		name   string
+		l1, l2 float64
+	}{
+		{"L1", 0.3, 0},
+		{"L2", 0, 0.7},
+		{"elastic net", 0.3, 0.7},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := randomVariable(r, 2, 3), randomVariable(r, 1, 2)
+			reg := ElasticNetRegularizer([]*VariableNode{a, b}, c.l1, c.l2)
+			checkGradients(t, reg, a, b)
+			// Added to a loss, the gradient is scaled by that of the sum.
+			x := randomVariable(r, 2, 3)
+			checkGradients(t, Add(Scale(reg, 2), project(r, MultiElement(x, a), 2, 3)), a, b, x)
+		})
+	}
+}
+
+func TestRegularizerGradientAtZero(t *testing.T) {
+	w := NewVariable(1, 3, []float64{0, 2, -2})
+	ElasticNetRegularizer([]*VariableNode{w}, 0.1, 0.5).Backward(NewConstMatrix(1, 1, 1))
+	// L1*sign(w) + L2*w, with the subgradient 0 of |w| at 0.
+	checkValues(t, "gradient", w.Gradient.Data, []float64{0, 1.1, -1.1})
+}
@@ -10,6 +10,37 @@ type Scaler interface {
 	Transform(data [][]float64) [][]float64
 }
 
+/*
+InverseScaler is implemented by scalers that can map transformed data back to
+its original scale.
+*/
+type InverseScaler interface {
+	Scaler
+	InverseTransform(data [][]float64) [][]float64
+}
+
+/*
+applyGroups returns a copy of data in which every value of a column in group i
+is replaced by f(i, value).
+*/
+func applyGroups(data [][]float64, dim int, groups [][]int, f func(group int, value float64) float64) [][]float64 {
+	result := make([][]float64, len(data))
+	for i, item := range data {
+		result[i] = make([]float64, len(item))
+		copy(result[i], item)
+	}
+	for i, group := range groups {
+		for p, item := range data {
+			for row := range len(item) / dim {
+				for _, col := range group {
+					result[p][row*dim+col] = f(i, item[row*dim+col])
+				}
+			}
+		}
+	}
+	return result
+}
+
 type MinMaxScaler struct {
 	Min    []float64 `json:"min"`
 	Max    []float64 `json:"max"`
@@ -63,6 +94,12 @@ func (m *MinMaxScaler) Transform(data [][]float64) [][]float64 {
 	return result
 }
 
+func (m *MinMaxScaler) InverseTransform(data [][]float64) [][]float64 {
+	return applyGroups(data, m.Dim, m.Groups, func(i int, v float64) float64 {
+		return v*(m.Max[i]-m.Min[i]) + m.Min[i]
+	})
+}
+
 type RobustScaler struct {
 	Median []float64 `json:"median"`
 	IQR    []float64 `json:"IQR"`
@@ -113,6 +150,12 @@ func (m *RobustScaler) Transform(data [][]float64) [][]float64 {
 	return result
 }
 
+func (m *RobustScaler) InverseTransform(data [][]float64) [][]float64 {
+	return applyGroups(data, m.Dim, m.Groups, func(i int, v float64) float64 {
+		return v*m.IQR[i] + m.Median[i]
+	})
+}
+
 type ZScoreScaler struct {
 	Mean         []float64 `json:"mean"`
 	StdDeviation []float64 `json:"stdDeviation"`
@@ -173,3 +216,9 @@ func (m *ZScoreScaler) Transform(data [][]float64) [][]float64 {
 	}
 	return result
 }
+
+func (m *ZScoreScaler) InverseTransform(data [][]float64) [][]float64 {
+	return applyGroups(data, m.Dim, m.Groups, func(i int, v float64) float64 {
+		return v*m.StdDeviation[i] + m.Mean[i]
+	})
+}
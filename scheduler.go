@@ -0,0 +1,328 @@
+package goraph
+
+import "math"
+
+/*
+LearningRateOptimizer is implemented by optimizers whose learning rate can be
+adjusted while training, which is what a Scheduler needs.
+*/
+type LearningRateOptimizer interface {
+	Optimizer
+	GetLearningRate() float64
+	SetLearningRate(learningRate float64)
+}
+
+/*
+Scheduler defines the interface for learning rate schedules. Step advances the
+schedule by one step, usually an epoch or a batch, and sets the new learning
+rate on the optimizer. The metric is only used by schedules that react to the
+training progress, such as ReduceOnPlateauScheduler. Apply sets the learning
+rate of the current step again, which is needed after the state of the
+scheduler has been restored, for example by Model.Load.
+
+The exported fields of a scheduler hold its complete state, so a scheduler
+attached to a Model is saved and restored along with the parameters, and a
+resumed training continues the schedule where it stopped.
+*/
+type Scheduler interface {
+	Step(metric float64)
+	Apply()
+	LearningRate() float64
+}
+
+/*
+StepDecayScheduler multiplies the learning rate by Gamma every StepSize steps.
+A StepSize of 0 or less never decays the learning rate.
+*/
+type StepDecayScheduler struct {
+	Optimizer        LearningRateOptimizer `json:"-"`
+	BaseLearningRate float64               `json:"base_learning_rate"`
+	StepSize         int                   `json:"step_size"`
+	Gamma            float64               `json:"gamma"`
+	Steps            int                   `json:"steps"`
+}
+
+func NewStepDecayScheduler(optimizer LearningRateOptimizer, stepSize int, gamma float64) *StepDecayScheduler {
+	s := &StepDecayScheduler{
+		Optimizer:        optimizer,
+		BaseLearningRate: optimizer.GetLearningRate(),
+		StepSize:         stepSize,
+		Gamma:            gamma,
+	}
+	s.Apply()
+	return s
+}
+
+func (s *StepDecayScheduler) LearningRate() float64 {
+	if s.StepSize <= 0 {
+		return s.BaseLearningRate
+	}
+	return s.BaseLearningRate * math.Pow(s.Gamma, float64(s.Steps/s.StepSize))
+}
+
+func (s *StepDecayScheduler) Step(metric float64) {
+	s.Steps++
+	s.Apply()
+}
+
+func (s *StepDecayScheduler) Apply() {
+	s.Optimizer.SetLearningRate(s.LearningRate())
+}
+
+/*
+ExponentialScheduler multiplies the learning rate by Gamma every step.
+*/
+type ExponentialScheduler struct {
+	Optimizer        LearningRateOptimizer `json:"-"`
+	BaseLearningRate float64               `json:"base_learning_rate"`
+	Gamma            float64               `json:"gamma"`
+	Steps            int                   `json:"steps"`
+}
+
+func NewExponentialScheduler(optimizer LearningRateOptimizer, gamma float64) *ExponentialScheduler {
+	s := &ExponentialScheduler{
+		Optimizer:        optimizer,
+		BaseLearningRate: optimizer.GetLearningRate(),
+		Gamma:            gamma,
+	}
+	s.Apply()
+	return s
+}
+
+func (s *ExponentialScheduler) LearningRate() float64 {
+	return s.BaseLearningRate * math.Pow(s.Gamma, float64(s.Steps))
+}
+
+func (s *ExponentialScheduler) Step(metric float64) {
+	s.Steps++
+	s.Apply()
+}
+
+func (s *ExponentialScheduler) Apply() {
+	s.Optimizer.SetLearningRate(s.LearningRate())
+}
+
+/*
+CosineAnnealingScheduler anneals the learning rate from its initial value to
+MinLearningRate along a half cosine over Period steps, then restarts. Each
+restart multiplies the length of the period by PeriodMult, which should be at
+least 1; a PeriodMult of 1 gives restarts at a fixed interval.
+*/
+type CosineAnnealingScheduler struct {
+	Optimizer        LearningRateOptimizer `json:"-"`
+	BaseLearningRate float64               `json:"base_learning_rate"`
+	MinLearningRate  float64               `json:"min_learning_rate"`
+	Period           int                   `json:"period"`
+	PeriodMult       float64               `json:"period_mult"`
+	Steps            int                   `json:"steps"`
+}
+
+func NewCosineAnnealingScheduler(optimizer LearningRateOptimizer, period int, periodMult, minLearningRate float64) *CosineAnnealingScheduler {
+	s := &CosineAnnealingScheduler{
+		Optimizer:        optimizer,
+		BaseLearningRate: optimizer.GetLearningRate(),
+		MinLearningRate:  minLearningRate,
+		Period:           period,
+		PeriodMult:       periodMult,
+	}
+	s.Apply()
+	return s
+}
+
+func (s *CosineAnnealingScheduler) LearningRate() float64 {
+	current := s.Steps
+	period := float64(s.Period)
+	for float64(current) >= period {
+		current -= int(period)
+		period = math.Max(1, math.Round(period*s.PeriodMult))
+	}
+	cos := (1 + math.Cos(math.Pi*float64(current)/period)) / 2
+	return s.MinLearningRate + (s.BaseLearningRate-s.MinLearningRate)*cos
+}
+
+func (s *CosineAnnealingScheduler) Step(metric float64) {
+	s.Steps++
+	s.Apply()
+}
+
+func (s *CosineAnnealingScheduler) Apply() {
+	s.Optimizer.SetLearningRate(s.LearningRate())
+}
+
+/*
+LinearWarmupScheduler raises the learning rate linearly from
+StartFactor*BaseLearningRate to BaseLearningRate over WarmupSteps steps. After
+the warm-up, the learning rate stays constant, or, if Then is set, the
+following steps are passed on to that scheduler.
+*/
+type LinearWarmupScheduler struct {
+	Optimizer        LearningRateOptimizer `json:"-"`
+	BaseLearningRate float64               `json:"base_learning_rate"`
+	StartFactor      float64               `json:"start_factor"`
+	WarmupSteps      int                   `json:"warmup_steps"`
+	Then             Scheduler             `json:"then,omitempty"`
+	Steps            int                   `json:"steps"`
+}
+
+/*
+NewLinearWarmupScheduler creates a warm-up schedule in front of then, which may
+be nil. Then must have been created for the same optimizer.
+*/
+func NewLinearWarmupScheduler(optimizer LearningRateOptimizer, warmupSteps int, startFactor float64, then Scheduler) *LinearWarmupScheduler {
+	baseLearningRate := optimizer.GetLearningRate()
+	if then != nil {
+		baseLearningRate = then.LearningRate()
+	}
+	s := &LinearWarmupScheduler{
+		Optimizer:        optimizer,
+		BaseLearningRate: baseLearningRate,
+		StartFactor:      startFactor,
+		WarmupSteps:      warmupSteps,
+		Then:             then,
+	}
+	s.Apply()
+	return s
+}
+
+func (s *LinearWarmupScheduler) LearningRate() float64 {
+	if s.Steps >= s.WarmupSteps {
+		if s.Then != nil {
+			return s.Then.LearningRate()
+		}
+		return s.BaseLearningRate
+	}
+	factor := s.StartFactor + (1-s.StartFactor)*float64(s.Steps)/float64(s.WarmupSteps)
+	return s.BaseLearningRate * factor
+}
+
+func (s *LinearWarmupScheduler) Step(metric float64) {
+	s.Steps++
+	if s.Steps > s.WarmupSteps && s.Then != nil {
+		s.Then.Step(metric)
+		return
+	}
+	s.Apply()
+}
+
+func (s *LinearWarmupScheduler) Apply() {
+	if s.Steps >= s.WarmupSteps && s.Then != nil {
+		s.Then.Apply()
+		return
+	}
+	s.Optimizer.SetLearningRate(s.LearningRate())
+}
+
+/*
+OneCycleScheduler implements the one-cycle policy over TotalSteps steps: the
+learning rate rises from MaxLearningRate/DivFactor to MaxLearningRate during
+the first PctStart of the steps, then falls to
+MaxLearningRate/DivFactor/FinalDivFactor, both along half cosines.
+*/
+type OneCycleScheduler struct {
+	Optimizer       LearningRateOptimizer `json:"-"`
+	MaxLearningRate float64               `json:"max_learning_rate"`
+	TotalSteps      int                   `json:"total_steps"`
+	PctStart        float64               `json:"pct_start"`
+	DivFactor       float64               `json:"div_factor"`
+	FinalDivFactor  float64               `json:"final_div_factor"`
+	Steps           int                   `json:"steps"`
+}
+
+func NewOneCycleScheduler(optimizer LearningRateOptimizer, maxLearningRate float64, totalSteps int) *OneCycleScheduler {
+	s := &OneCycleScheduler{
+		Optimizer:       optimizer,
+		MaxLearningRate: maxLearningRate,
+		TotalSteps:      totalSteps,
+		PctStart:        0.3,
+		DivFactor:       25,
+		FinalDivFactor:  1e4,
+	}
+	s.Apply()
+	return s
+}
+
+func (s *OneCycleScheduler) LearningRate() float64 {
+	initial := s.MaxLearningRate / s.DivFactor
+	final := initial / s.FinalDivFactor
+	upSteps := math.Max(1, s.PctStart*float64(s.TotalSteps))
+	downSteps := math.Max(1, float64(s.TotalSteps)-upSteps)
+	step := math.Min(float64(s.Steps), float64(s.TotalSteps))
+	anneal := func(from, to, pct float64) float64 {
+		return to + (from-to)*(1+math.Cos(math.Pi*pct))/2
+	}
+	if step <= upSteps {
+		return anneal(initial, s.MaxLearningRate, step/upSteps)
+	}
+	return anneal(s.MaxLearningRate, final, (step-upSteps)/downSteps)
+}
+
+func (s *OneCycleScheduler) Step(metric float64) {
+	s.Steps++
+	s.Apply()
+}
+
+func (s *OneCycleScheduler) Apply() {
+	s.Optimizer.SetLearningRate(s.LearningRate())
+}
+
+/*
+ReduceOnPlateauScheduler multiplies the learning rate by Factor when the metric
+passed to Step, usually a validation loss, has not improved by more than
+Threshold for Patience steps. The learning rate never goes below
+MinLearningRate.
+*/
+type ReduceOnPlateauScheduler struct {
+	Optimizer           LearningRateOptimizer `json:"-"`
+	Factor              float64               `json:"factor"`
+	Patience            int                   `json:"patience"`
+	Threshold           float64               `json:"threshold"`
+	MinLearningRate     float64               `json:"min_learning_rate"`
+	Maximize            bool                  `json:"maximize"`
+	CurrentLearningRate float64               `json:"current_learning_rate"`
+	Best                float64               `json:"best"`
+	Wait                int                   `json:"wait"`
+	Steps               int                   `json:"steps"`
+}
+
+func NewReduceOnPlateauScheduler(optimizer LearningRateOptimizer, factor float64, patience int, minLearningRate float64) *ReduceOnPlateauScheduler {
+	s := &ReduceOnPlateauScheduler{
+		Optimizer:           optimizer,
+		Factor:              factor,
+		Patience:            patience,
+		MinLearningRate:     minLearningRate,
+		CurrentLearningRate: optimizer.GetLearningRate(),
+	}
+	s.Apply()
+	return s
+}
+
+func (s *ReduceOnPlateauScheduler) LearningRate() float64 {
+	return s.CurrentLearningRate
+}
+
+func (s *ReduceOnPlateauScheduler) Step(metric float64) {
+	improved := s.Steps == 0
+	if !improved {
+		if s.Maximize {
+			improved = metric > s.Best+s.Threshold
+		} else {
+			improved = metric < s.Best-s.Threshold
+		}
+	}
+	s.Steps++
+	if improved {
+		s.Best = metric
+		s.Wait = 0
+	} else {
+		s.Wait++
+		if s.Wait > s.Patience {
+			s.CurrentLearningRate = math.Max(s.MinLearningRate, s.CurrentLearningRate*s.Factor)
+			s.Wait = 0
+		}
+	}
+	s.Apply()
+}
+
+func (s *ReduceOnPlateauScheduler) Apply() {
+	s.Optimizer.SetLearningRate(s.CurrentLearningRate)
+}
@@ -0,0 +1,14 @@
+package goraph
+
+import "testing"
+
+func TestStepDecaySchedulerZeroStepSize(t *testing.T) {
+	optimizer := NewSGDOptimizer(nil, 0.1, 0)
+	s := NewStepDecayScheduler(optimizer, 0, 0.5)
+	for range 3 {
+		s.Step(0)
+	}
+	if got := optimizer.GetLearningRate(); got != 0.1 {
+		t.Fatalf("learning rate %v, want 0.1", got)
+	}
+}
@@ -0,0 +1,438 @@
+package goraph
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+/*
+Tensor defines an n-dimensional array of float64 values. Element (i0, i1, ...)
+is stored at Data[i0*Strides[0]+i1*Strides[1]+...]. Tensors created by the
+constructors are contiguous in row-major order; Transpose and Permute return
+views that share Data with the original tensor, as does Reshape for a
+contiguous tensor, while all arithmetic operations return new contiguous
+tensors.
+
+The graph nodes operate on matrices. A tensor enters a graph through
+NewTensorVariable, which flattens it to a matrix, and a value or gradient
+comes back out as a tensor through Matrix.Tensor and Reshape, which do not
+copy.
+*/
+type Tensor struct {
+	Data    []float64 `json:"data"`
+	Shape   []int     `json:"shape"`
+	Strides []int     `json:"strides"`
+}
+
+func NewTensor(shape []int, data []float64) *Tensor {
+	if len(data) != shapeSize(shape) {
+		panic("Data length does not match tensor shape")
+	}
+	return &Tensor{
+		Data:    data,
+		Shape:   slices.Clone(shape),
+		Strides: contiguousStrides(shape),
+	}
+}
+
+func NewConstTensor(shape []int, value float64) *Tensor {
+	data := make([]float64, shapeSize(shape))
+	for i := range data {
+		data[i] = value
+	}
+	return NewTensor(shape, data)
+}
+
+func NewRandomTensor(shape []int, f func() float64) *Tensor {
+	data := make([]float64, shapeSize(shape))
+	for i := range data {
+		data[i] = f()
+	}
+	return NewTensor(shape, data)
+}
+
+func shapeSize(shape []int) int {
+	size := 1
+	for _, dim := range shape {
+		if dim < 0 {
+			panic("Tensor dimensions must not be negative")
+		}
+		size *= dim
+	}
+	return size
+}
+
+func contiguousStrides(shape []int) []int {
+	strides := make([]int, len(shape))
+	stride := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= shape[i]
+	}
+	return strides
+}
+
+func (t *Tensor) String() string {
+	c := t.Contiguous()
+	str := "Shape:["
+	for i, dim := range t.Shape {
+		if i > 0 {
+			str += ","
+		}
+		str += fmt.Sprintf("%d", dim)
+	}
+	str += fmt.Sprintf("], Data:%v", c.Data)
+	return str
+}
+
+func (t *Tensor) Rank() int {
+	return len(t.Shape)
+}
+
+func (t *Tensor) Size() int {
+	return shapeSize(t.Shape)
+}
+
+func (t *Tensor) offset(indices []int) int {
+	if len(indices) != len(t.Shape) {
+		panic("Number of indices does not match tensor rank")
+	}
+	offset := 0
+	for i, idx := range indices {
+		if idx < 0 || idx >= t.Shape[i] {
+			panic("Tensor index out of range")
+		}
+		offset += idx * t.Strides[i]
+	}
+	return offset
+}
+
+func (t *Tensor) At(indices ...int) float64 {
+	return t.Data[t.offset(indices)]
+}
+
+func (t *Tensor) Set(value float64, indices ...int) {
+	t.Data[t.offset(indices)] = value
+}
+
+/*
+IsContiguous reports whether the elements are stored in row-major order
+without gaps, so that Data can be used directly.
+*/
+func (t *Tensor) IsContiguous() bool {
+	return len(t.Data) == t.Size() && slices.Equal(t.Strides, contiguousStrides(t.Shape))
+}
+
+/*
+Contiguous returns the tensor itself if it is contiguous, otherwise a
+contiguous copy of it.
+*/
+func (t *Tensor) Contiguous() *Tensor {
+	if t.IsContiguous() {
+		return t
+	}
+	data := make([]float64, t.Size())
+	indices := make([]int, len(t.Shape))
+	for i := range data {
+		offset := 0
+		for axis, idx := range indices {
+			offset += idx * t.Strides[axis]
+		}
+		data[i] = t.Data[offset]
+		for axis := len(indices) - 1; axis >= 0; axis-- {
+			indices[axis]++
+			if indices[axis] < t.Shape[axis] {
+				break
+			}
+			indices[axis] = 0
+		}
+	}
+	return NewTensor(t.Shape, data)
+}
+
+func (t *Tensor) Clone() *Tensor {
+	c := t.Contiguous()
+	return NewTensor(c.Shape, slices.Clone(c.Data))
+}
+
+/*
+Reshape returns the tensor with a new shape. One dimension may be -1, in which
+case it is inferred from the number of elements. The result is a view sharing
+Data if the tensor is contiguous; otherwise the elements are first copied into
+a contiguous tensor, as Contiguous does.
+*/
+func (t *Tensor) Reshape(shape ...int) *Tensor {
+	shape = slices.Clone(shape)
+	inferred := -1
+	size := 1
+	for i, dim := range shape {
+		if dim == -1 {
+			if inferred >= 0 {
+				panic("Only one dimension can be inferred")
+			}
+			inferred = i
+			continue
+		}
+		size *= dim
+	}
+	if inferred >= 0 {
+		if size == 0 || t.Size()%size != 0 {
+			panic("Cannot infer dimension for reshape")
+		}
+		shape[inferred] = t.Size() / size
+	}
+	if shapeSize(shape) != t.Size() {
+		panic("Tensor size does not match the new shape")
+	}
+	c := t.Contiguous()
+	return &Tensor{
+		Data:    c.Data,
+		Shape:   shape,
+		Strides: contiguousStrides(shape),
+	}
+}
+
+/*
+Permute returns a view of the tensor whose axis i is axis axes[i] of the
+original tensor.
+*/
+func (t *Tensor) Permute(axes ...int) *Tensor {
+	if len(axes) != len(t.Shape) {
+		panic("Number of axes does not match tensor rank")
+	}
+	shape := make([]int, len(axes))
+	strides := make([]int, len(axes))
+	seen := make([]bool, len(axes))
+	for i, axis := range axes {
+		if axis < 0 || axis >= len(t.Shape) || seen[axis] {
+			panic("Invalid permutation")
+		}
+		seen[axis] = true
+		shape[i] = t.Shape[axis]
+		strides[i] = t.Strides[axis]
+	}
+	return &Tensor{
+		Data:    t.Data,
+		Shape:   shape,
+		Strides: strides,
+	}
+}
+
+/*
+Transpose returns a view of the tensor with axes a and b swapped.
+*/
+func (t *Tensor) Transpose(a, b int) *Tensor {
+	axes := make([]int, len(t.Shape))
+	for i := range axes {
+		axes[i] = i
+	}
+	axes[a], axes[b] = axes[b], axes[a]
+	return t.Permute(axes...)
+}
+
+func (t *Tensor) Apply(f func(float64) float64) *Tensor {
+	c := t.Contiguous()
+	data := make([]float64, len(c.Data))
+	for i, v := range c.Data {
+		data[i] = f(v)
+	}
+	return NewTensor(c.Shape, data)
+}
+
+func (t *Tensor) elementWise(other *Tensor, f func(a, b float64) float64) *Tensor {
+	if !slices.Equal(t.Shape, other.Shape) {
+		panic("Tensor shapes do not match")
+	}
+	a := t.Contiguous()
+	b := other.Contiguous()
+	data := make([]float64, len(a.Data))
+	for i := range data {
+		data[i] = f(a.Data[i], b.Data[i])
+	}
+	return NewTensor(a.Shape, data)
+}
+
+func (t *Tensor) Add(other *Tensor) *Tensor {
+	return t.elementWise(other, func(a, b float64) float64 { return a + b })
+}
+
+func (t *Tensor) Sub(other *Tensor) *Tensor {
+	return t.elementWise(other, func(a, b float64) float64 { return a - b })
+}
+
+func (t *Tensor) MultiElement(other *Tensor) *Tensor {
+	return t.elementWise(other, func(a, b float64) float64 { return a * b })
+}
+
+func (t *Tensor) DivElement(other *Tensor) *Tensor {
+	return t.elementWise(other, func(a, b float64) float64 { return a / b })
+}
+
+func (t *Tensor) Scale(rate float64) *Tensor {
+	return t.Apply(func(v float64) float64 { return v * rate })
+}
+
+func (t *Tensor) Negate() *Tensor {
+	return t.Apply(func(v float64) float64 { return -v })
+}
+
+/*
+reduce folds the given axis with f, starting from init, and returns a tensor
+with that axis removed.
+*/
+func (t *Tensor) reduce(axis int, init float64, f func(acc, v float64) float64) *Tensor {
+	if axis < 0 {
+		axis += len(t.Shape)
+	}
+	if axis < 0 || axis >= len(t.Shape) {
+		panic("Axis out of range")
+	}
+	c := t.Contiguous()
+	outer := shapeSize(c.Shape[:axis])
+	inner := shapeSize(c.Shape[axis+1:])
+	dim := c.Shape[axis]
+	data := make([]float64, outer*inner)
+	for o := range outer {
+		for i := range inner {
+			acc := init
+			for d := range dim {
+				acc = f(acc, c.Data[(o*dim+d)*inner+i])
+			}
+			data[o*inner+i] = acc
+		}
+	}
+	shape := slices.Concat(c.Shape[:axis], c.Shape[axis+1:])
+	return NewTensor(shape, data)
+}
+
+/*
+Sum returns the sum along the given axis; negative axes count from the end.
+*/
+func (t *Tensor) Sum(axis int) *Tensor {
+	return t.reduce(axis, 0, func(acc, v float64) float64 { return acc + v })
+}
+
+func (t *Tensor) Mean(axis int) *Tensor {
+	if axis < 0 {
+		axis += len(t.Shape)
+	}
+	return t.Sum(axis).Scale(1 / float64(t.Shape[axis]))
+}
+
+func (t *Tensor) Max(axis int) *Tensor {
+	return t.reduce(axis, math.Inf(-1), func(acc, v float64) float64 { return max(acc, v) })
+}
+
+func (t *Tensor) Min(axis int) *Tensor {
+	return t.reduce(axis, math.Inf(1), func(acc, v float64) float64 { return min(acc, v) })
+}
+
+func (t *Tensor) SumAll() float64 {
+	sum := 0.0
+	for _, v := range t.Contiguous().Data {
+		sum += v
+	}
+	return sum
+}
+
+/*
+MatMul multiplies the two trailing dimensions of the tensors as matrices. The
+leading dimensions are batch dimensions and must be equal, unless one of the
+tensors has rank 2, in which case it is used for every batch.
+*/
+func (t *Tensor) MatMul(other *Tensor) *Tensor {
+	if t.Rank() < 2 || other.Rank() < 2 {
+		panic("MatMul requires tensors of rank 2 or more")
+	}
+	a := t.Contiguous()
+	b := other.Contiguous()
+	n, k := a.Shape[a.Rank()-2], a.Shape[a.Rank()-1]
+	if b.Shape[b.Rank()-2] != k {
+		panic("Tensor dimensions do not match")
+	}
+	m := b.Shape[b.Rank()-1]
+	aBatch := a.Shape[:a.Rank()-2]
+	bBatch := b.Shape[:b.Rank()-2]
+	var batch []int
+	switch {
+	case len(bBatch) == 0:
+		batch = aBatch
+	case len(aBatch) == 0:
+		batch = bBatch
+	case slices.Equal(aBatch, bBatch):
+		batch = aBatch
+	default:
+		panic("Tensor batch dimensions do not match")
+	}
+	batchSize := shapeSize(batch)
+	data := make([]float64, batchSize*n*m)
+	for bi := range batchSize {
+		aOffset, bOffset := 0, 0
+		if len(aBatch) > 0 {
+			aOffset = bi * n * k
+		}
+		if len(bBatch) > 0 {
+			bOffset = bi * k * m
+		}
+		out := data[bi*n*m : (bi+1)*n*m]
+		for r := range n {
+			for c1 := range k {
+				av := a.Data[aOffset+r*k+c1]
+				for c2 := range m {
+					out[r*m+c2] += av * b.Data[bOffset+c1*m+c2]
+				}
+			}
+		}
+	}
+	return NewTensor(slices.Concat(batch, []int{n, m}), data)
+}
+
+/*
+Matrix converts a rank-2 tensor to a Matrix, so it can be used as the value of
+a VariableNode or passed to any Matrix operation.
+*/
+func (t *Tensor) Matrix() *Matrix {
+	if t.Rank() != 2 {
+		panic("Only rank-2 tensors can be converted to a matrix")
+	}
+	return t.Flatten(1)
+}
+
+/*
+Flatten converts the tensor to a Matrix whose rows run over the axes before
+axis and whose columns run over axis and the axes after it. This gives the
+layouts the nodes expect: a [N,C,H,W] batch of images flattened at axis 2 is
+the [N*C, H*W] input of Conv2D, and flattened at axis 1 it is a [N, C*H*W]
+matrix with one sample per row, the input of the dense layers and losses.
+The matrix shares Data with the tensor if the tensor is contiguous.
+*/
+func (t *Tensor) Flatten(axis int) *Matrix {
+	if axis < 0 || axis > t.Rank() {
+		panic("Flatten axis out of range")
+	}
+	c := t.Contiguous()
+	return NewMatrix(shapeSize(c.Shape[:axis]), shapeSize(c.Shape[axis:]), c.Data)
+}
+
+/*
+Tensor converts the matrix to a rank-2 tensor sharing the same data. Reshape
+turns it back into the tensor it was flattened from, also without copying,
+for example Value.Tensor().Reshape(N, C, H, W) for the value of a node.
+*/
+func (m *Matrix) Tensor() *Tensor {
+	return NewTensor([]int{m.Rows, m.Cols}, m.Data)
+}
+
+/*
+NewTensorVariable creates a variable node whose value is the given tensor,
+flattened at axis as Flatten does, so that a tensor of any rank can be passed
+to the nodes. For a rank-2 tensor, axis 1 keeps its rows and columns.
+*/
+func NewTensorVariable(t *Tensor, axis int) *VariableNode {
+	value := t.Flatten(axis)
+	return &VariableNode{
+		Value:    value,
+		Gradient: NewConstMatrix(value.Rows, value.Cols, 0.0),
+	}
+}
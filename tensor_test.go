@@ -0,0 +1,160 @@
+package goraph
+
+import (
+	"slices"
+	"testing"
+)
+
+/*
+checkTensor compares the shape and the elements, in row-major order, of a
+tensor with the expected ones.
+*/
+func checkTensor(t *testing.T, got *Tensor, shape []int, data []float64) {
+	t.Helper()
+	if !slices.Equal(got.Shape, shape) {
+		t.Fatalf("shape %v, want %v", got.Shape, shape)
+	}
+	if c := got.Contiguous(); !slices.Equal(c.Data, data) {
+		t.Fatalf("data %v, want %v", c.Data, data)
+	}
+}
+
+func TestTensorIndexing(t *testing.T) {
+	x := NewTensor([]int{2, 3, 4}, make([]float64, 24))
+	if !slices.Equal(x.Strides, []int{12, 4, 1}) {
+		t.Fatalf("strides %v, want [12 4 1]", x.Strides)
+	}
+	x.Set(5, 1, 2, 3)
+	if x.Data[23] != 5 || x.At(1, 2, 3) != 5 {
+		t.Fatalf("element (1, 2, 3) is not stored at offset 23")
+	}
+	if x.Rank() != 3 || x.Size() != 24 {
+		t.Fatalf("rank %d and size %d, want 3 and 24", x.Rank(), x.Size())
+	}
+}
+
+func TestTensorReshape(t *testing.T) {
+	x := NewTensor([]int{2, 3}, []float64{1, 2, 3, 4, 5, 6})
+	y := x.Reshape(3, -1)
+	checkTensor(t, y, []int{3, 2}, []float64{1, 2, 3, 4, 5, 6})
+	y.Set(10, 0, 0)
+	if x.At(0, 0) != 10 {
+		t.Fatalf("reshape of a contiguous tensor is not a view")
+	}
+	// The transpose is not contiguous, so its reshape copies the elements in
+	// the order of the transpose.
+	tr := x.Transpose(0, 1)
+	z := tr.Reshape(6)
+	checkTensor(t, z, []int{6}, []float64{10, 4, 2, 5, 3, 6})
+	z.Set(0, 0)
+	if x.At(0, 0) != 10 {
+		t.Fatalf("reshape of a non-contiguous tensor shares data")
+	}
+}
+
+func TestTensorPermute(t *testing.T) {
+	data := make([]float64, 24)
+	for i := range data {
+		data[i] = float64(i)
+	}
+	x := NewTensor([]int{2, 3, 4}, data)
+	y := x.Permute(2, 0, 1)
+	if !slices.Equal(y.Shape, []int{4, 2, 3}) || y.IsContiguous() {
+		t.Fatalf("permuted shape %v, want a non-contiguous [4 2 3] view", y.Shape)
+	}
+	for i := range 2 {
+		for j := range 3 {
+			for k := range 4 {
+				if y.At(k, i, j) != x.At(i, j, k) {
+					t.Fatalf("element (%d, %d, %d) was not moved to (%d, %d, %d)", i, j, k, k, i, j)
+				}
+			}
+		}
+	}
+	if c := y.Contiguous(); !c.IsContiguous() || c.At(3, 1, 2) != x.At(1, 2, 3) {
+		t.Fatalf("contiguous copy of the permutation is wrong")
+	}
+	tr := x.Transpose(0, 2)
+	if !slices.Equal(tr.Shape, []int{4, 3, 2}) || tr.At(3, 1, 0) != x.At(0, 1, 3) {
+		t.Fatalf("transpose of axes 0 and 2 is wrong")
+	}
+}
+
+func TestTensorMatMul(t *testing.T) {
+	a := NewTensor([]int{2, 3}, []float64{1, 2, 3, 4, 5, 6})
+	b := NewTensor([]int{3, 2}, []float64{7, 8, 9, 10, 11, 12})
+	checkTensor(t, a.MatMul(b), []int{2, 2}, []float64{58, 64, 139, 154})
+
+	// A batch of two matrices times a rank-2 tensor multiplies every batch.
+	batch := NewTensor([]int{2, 2, 3}, []float64{1, 2, 3, 4, 5, 6, 1, 0, 0, 0, 1, 0})
+	checkTensor(t, batch.MatMul(b), []int{2, 2, 2}, []float64{58, 64, 139, 154, 7, 8, 9, 10})
+
+	// A non-contiguous operand gives the same product as its copy.
+	checkTensor(t, b.Transpose(0, 1).MatMul(a.Transpose(0, 1)), []int{2, 2}, []float64{58, 139, 64, 154})
+}
+
+func TestTensorFlatten(t *testing.T) {
+	data := make([]float64, 24)
+	for i := range data {
+		data[i] = float64(i)
+	}
+	x := NewTensor([]int{2, 3, 2, 2}, data)
+	conv := x.Flatten(2)
+	if conv.Rows != 6 || conv.Cols != 4 {
+		t.Fatalf("flatten at axis 2 gives %dx%d, want 6x4", conv.Rows, conv.Cols)
+	}
+	dense := x.Flatten(1)
+	if dense.Rows != 2 || dense.Cols != 12 || dense.Data[13] != 13 {
+		t.Fatalf("flatten at axis 1 gives %dx%d, want 2x12 in row-major order", dense.Rows, dense.Cols)
+	}
+	checkTensor(t, dense.Tensor().Reshape(2, 3, 2, 2), x.Shape, data)
+
+	// A permuted tensor is copied in the order of the permutation.
+	p := x.Permute(1, 0, 2, 3).Flatten(1)
+	if p.Data[4] != x.At(1, 0, 0, 0) {
+		t.Fatalf("flatten of a permuted tensor is not in the permuted order")
+	}
+}
+
+func TestNewTensorVariable(t *testing.T) {
+	x := NewTensor([]int{2, 2, 3}, make([]float64, 12))
+	v := NewTensorVariable(x, 1)
+	if v.Value.Rows != 2 || v.Value.Cols != 6 {
+		t.Fatalf("variable is %dx%d, want 2x6", v.Value.Rows, v.Value.Cols)
+	}
+	if v.Gradient.Rows != 2 || v.Gradient.Cols != 6 {
+		t.Fatalf("gradient is %dx%d, want 2x6", v.Gradient.Rows, v.Gradient.Cols)
+	}
+	v.Value.Data[0] = 1
+	if x.Data[0] != 1 {
+		t.Fatalf("variable of a contiguous tensor does not share data")
+	}
+}
+
+func TestTensorMatMulGradients(t *testing.T) {
+	r := testRand()
+	random := func() float64 { return r.Float64()*2 - 1 }
+	ta := NewRandomTensor([]int{3, 2}, random)
+	tb := NewRandomTensor([]int{3, 4}, random)
+	tw := NewRandomTensor([]int{2, 4}, random)
+	a, b := NewTensorVariable(ta, 1), NewTensorVariable(tb, 1)
+	w := NewTensorVariable(tw, 1)
+	w.Freeze()
+
+	// The graph computes a^T b, the tensors Permute(1, 0) and MatMul.
+	product := Multi(Trans(a), b)
+	want := ta.Permute(1, 0).MatMul(tb)
+	checkTensor(t, product.Forward().Tensor(), want.Shape, want.Contiguous().Data)
+
+	// The gradient of sum(w * a^T b) with respect to a is b w^T.
+	loss := ColSum(RowSum(MultiElement(product, w)))
+	loss.Forward()
+	loss.Backward(NewConstMatrix(1, 1, 1))
+	grad := tb.MatMul(tw.Permute(1, 0))
+	for i, g := range grad.Data {
+		if diff := a.Gradient.Data[i] - g; diff > 1e-12 || diff < -1e-12 {
+			t.Fatalf("gradient %v, want %v", a.Gradient.Data, grad.Data)
+		}
+	}
+	checkGradients(t, loss, a, b)
+}
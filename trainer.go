@@ -0,0 +1,338 @@
+package goraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+/*
+Callback defines the interface for observing the progress of a Trainer.
+OnBatchEnd is called after every optimization step with the mean loss of the
+batch, and OnEpochEnd after every epoch with the logs of that epoch.
+*/
+type Callback interface {
+	OnBatchEnd(epoch, batch int, loss float64)
+	OnEpochEnd(epoch int, logs map[string]float64)
+}
+
+/*
+ProgressCallback prints the logs of every epoch to Writer.
+*/
+type ProgressCallback struct {
+	Writer io.Writer
+}
+
+func NewProgressCallback(writer io.Writer) *ProgressCallback {
+	return &ProgressCallback{
+		Writer: writer,
+	}
+}
+
+func (p *ProgressCallback) OnBatchEnd(epoch, batch int, loss float64) {
+}
+
+func (p *ProgressCallback) OnEpochEnd(epoch int, logs map[string]float64) {
+	str := fmt.Sprintf("Epoch: %d", epoch)
+	keys := make([]string, 0, len(logs))
+	for key := range logs {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		str += fmt.Sprintf(", %s: %v", key, logs[key])
+	}
+	fmt.Fprintln(p.Writer, str)
+}
+
+/*
+monitor tracks the best value of one entry of the epoch logs.
+*/
+type monitor struct {
+	name     string
+	maximize bool
+	minDelta float64
+	best     float64
+	seen     bool
+}
+
+/*
+improved reports whether the monitored value of the logs is better than the
+best value so far by more than minDelta, and records it if so.
+*/
+func (m *monitor) improved(logs map[string]float64) (bool, error) {
+	value, ok := logs[m.name]
+	if !ok {
+		return false, fmt.Errorf("monitored value %q is not in the training logs", m.name)
+	}
+	better := !m.seen
+	if m.seen {
+		if m.maximize {
+			better = value > m.best+m.minDelta
+		} else {
+			better = value < m.best-m.minDelta
+		}
+	}
+	if better {
+		m.best = value
+		m.seen = true
+	}
+	return better, nil
+}
+
+/*
+EarlyStopping stops training when the monitored entry of the epoch logs, such
+as "val_loss", has not improved by more than MinDelta for Patience epochs.
+*/
+type EarlyStopping struct {
+	Monitor  string
+	Maximize bool
+	Patience int
+	MinDelta float64
+	monitor  monitor
+	wait     int
+}
+
+func NewEarlyStopping(monitor string, maximize bool, patience int, minDelta float64) *EarlyStopping {
+	return &EarlyStopping{
+		Monitor:  monitor,
+		Maximize: maximize,
+		Patience: patience,
+		MinDelta: minDelta,
+	}
+}
+
+func (e *EarlyStopping) reset() {
+	e.monitor = monitor{name: e.Monitor, maximize: e.Maximize, minDelta: e.MinDelta}
+	e.wait = 0
+}
+
+func (e *EarlyStopping) stop(logs map[string]float64) (bool, error) {
+	improved, err := e.monitor.improved(logs)
+	if err != nil {
+		return false, err
+	}
+	if improved {
+		e.wait = 0
+		return false, nil
+	}
+	e.wait++
+	return e.wait >= e.Patience, nil
+}
+
+/*
+ModelCheckpoint saves Model to FilePath whenever the monitored entry of the
+epoch logs reaches a new best value, so the file always holds the best model
+seen during training.
+*/
+type ModelCheckpoint struct {
+	Model    *Model
+	FilePath string
+	Monitor  string
+	Maximize bool
+	monitor  monitor
+}
+
+func NewModelCheckpoint(model *Model, filePath, monitor string, maximize bool) *ModelCheckpoint {
+	return &ModelCheckpoint{
+		Model:    model,
+		FilePath: filePath,
+		Monitor:  monitor,
+		Maximize: maximize,
+	}
+}
+
+func (c *ModelCheckpoint) reset() {
+	c.monitor = monitor{name: c.Monitor, maximize: c.Maximize}
+}
+
+func (c *ModelCheckpoint) update(logs map[string]float64) error {
+	improved, err := c.monitor.improved(logs)
+	if err != nil || !improved {
+		return err
+	}
+	return c.Model.Save(c.FilePath)
+}
+
+/*
+TrainingCheckpoint saves the complete training state of the network and Model
+to FilePath every Every epochs, as NeuralNetwork.SaveCheckpoint does, so that
+an interrupted training can be continued with NeuralNetwork.Resume.
+*/
+type TrainingCheckpoint struct {
+	Model    *Model
+	FilePath string
+	Every    int
+}
+
+func NewTrainingCheckpoint(model *Model, filePath string, every int) *TrainingCheckpoint {
+	return &TrainingCheckpoint{
+		Model:    model,
+		FilePath: filePath,
+		Every:    every,
+	}
+}
+
+/*
+Trainer runs the training loop of a NeuralNetwork for several epochs. Every
+epoch the training samples are optionally shuffled and trained in batches of
+BatchSize. The logs of an epoch hold the mean training "loss" and, if
+validation data is set, the "val_loss" and "val_" followed by the name of each
+metric. The logs are passed to the callbacks, the checkpoint and the early
+stopping in that order.
+
+If Scheduler is set, it is stepped after every epoch with the validation loss,
+or with the training loss when there is no validation data, and the learning
+rate used in the epoch is logged as "lr". With SchedulePerBatch it is stepped
+after every optimization step with the loss of the last batch instead, as
+warm-up and one-cycle schedules expect. The gradients accumulated in an epoch
+are applied at its end even if fewer than AccumulationSteps batches are
+pending.
+
+Epochs is the total number of epochs, counted by the network: a network
+restored with NeuralNetwork.Resume continues with the epoch after the saved
+one. Seed seeds the random source of the network used for shuffling, unless
+the network already has one. The state of the early stopping and of the
+model checkpoint is not saved, and starts over in every call to Fit.
+*/
+type Trainer struct {
+	Network           *NeuralNetwork
+	Epochs            int
+	BatchSize         int
+	Shuffle           bool
+	Seed              uint64
+	ValidationInputs  [][]float64
+	ValidationTargets [][]float64
+	Metrics           []Metric
+	Callbacks         []Callback
+	EarlyStopping     *EarlyStopping
+	Checkpoint        *ModelCheckpoint
+	Resumable         *TrainingCheckpoint
+	Scheduler         Scheduler
+	SchedulePerBatch  bool
+}
+
+func NewTrainer(nn *NeuralNetwork, epochs, batchSize int) *Trainer {
+	return &Trainer{
+		Network:   nn,
+		Epochs:    epochs,
+		BatchSize: batchSize,
+		Shuffle:   true,
+	}
+}
+
+/*
+Fit trains the network and returns the logs of every epoch. It stops early
+when the context is cancelled, returning the logs so far along with the error
+of the context. It returns an error without training if BatchSize is not
+positive, or if the training or validation data is empty or has a different
+number of inputs and targets.
+*/
+func (t *Trainer) Fit(ctx context.Context, inputData, targetData [][]float64) (history []map[string]float64, err error) {
+	if t.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", t.BatchSize)
+	}
+	if len(inputData) == 0 {
+		return nil, errors.New("no training data")
+	}
+	if len(inputData) != len(targetData) {
+		return nil, fmt.Errorf("%d training inputs but %d targets", len(inputData), len(targetData))
+	}
+	if t.ValidationInputs != nil {
+		if len(t.ValidationInputs) == 0 {
+			return nil, errors.New("no validation data")
+		}
+		if len(t.ValidationInputs) != len(t.ValidationTargets) {
+			return nil, fmt.Errorf("%d validation inputs but %d targets", len(t.ValidationInputs), len(t.ValidationTargets))
+		}
+	}
+	t.Network.seed(t.Seed)
+	if t.EarlyStopping != nil {
+		t.EarlyStopping.reset()
+	}
+	if t.Checkpoint != nil {
+		t.Checkpoint.reset()
+	}
+	indices := make([]int, len(inputData))
+	batchInputs := make([][]float64, 0, t.BatchSize)
+	batchTargets := make([][]float64, 0, t.BatchSize)
+	for epoch := t.Network.epoch; epoch < t.Epochs; epoch++ {
+		// The order of an epoch depends only on the random source, which
+		// is saved in checkpoints, not on the order of the previous epoch.
+		for i := range indices {
+			indices[i] = i
+		}
+		if t.Shuffle {
+			t.Network.rand.Shuffle(len(indices), func(i, j int) {
+				indices[i], indices[j] = indices[j], indices[i]
+			})
+		}
+		lossValue := 0.0
+		learningRate := 0.0
+		if t.Scheduler != nil {
+			learningRate = t.Scheduler.LearningRate()
+		}
+		for batch, start := 0, 0; start < len(indices); batch, start = batch+1, start+t.BatchSize {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			batchInputs, batchTargets = batchInputs[:0], batchTargets[:0]
+			for _, idx := range indices[start:min(len(indices), start+t.BatchSize)] {
+				batchInputs = append(batchInputs, inputData[idx])
+				batchTargets = append(batchTargets, targetData[idx])
+			}
+			steps := t.Network.step
+			batchLoss := t.Network.TrainBatch(batchInputs, batchTargets)
+			lossValue += batchLoss * float64(len(batchInputs))
+			if t.Scheduler != nil && t.SchedulePerBatch && t.Network.step > steps {
+				t.Scheduler.Step(batchLoss)
+			}
+			for _, callback := range t.Callbacks {
+				callback.OnBatchEnd(epoch, batch, batchLoss)
+			}
+		}
+		t.Network.flush()
+		logs := map[string]float64{"loss": lossValue / float64(len(inputData))}
+		if t.ValidationInputs != nil {
+			valLoss, outputData := t.Network.Evaluate(t.ValidationInputs, t.ValidationTargets)
+			logs["val_loss"] = valLoss
+			for _, metric := range t.Metrics {
+				logs["val_"+metric.Name()] = metric.Compute(outputData, t.ValidationTargets)
+			}
+		}
+		if t.Scheduler != nil {
+			logs["lr"] = learningRate
+			if !t.SchedulePerBatch {
+				if valLoss, ok := logs["val_loss"]; ok {
+					t.Scheduler.Step(valLoss)
+				} else {
+					t.Scheduler.Step(logs["loss"])
+				}
+			}
+		}
+		t.Network.epoch++
+		history = append(history, logs)
+		for _, callback := range t.Callbacks {
+			callback.OnEpochEnd(epoch, logs)
+		}
+		if t.Checkpoint != nil {
+			if err = t.Checkpoint.update(logs); err != nil {
+				return
+			}
+		}
+		if t.Resumable != nil && t.Network.epoch%max(1, t.Resumable.Every) == 0 {
+			if err = t.Network.SaveCheckpoint(t.Resumable.FilePath, t.Resumable.Model); err != nil {
+				return
+			}
+		}
+		if t.EarlyStopping != nil {
+			var stop bool
+			if stop, err = t.EarlyStopping.stop(logs); err != nil || stop {
+				return
+			}
+		}
+	}
+	return
+}
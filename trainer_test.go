@@ -0,0 +1,150 @@
+package goraph
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/*
+scriptedMetric returns the next of a fixed sequence of values every time it
+is computed, so that the epochs in which it improves are known in advance.
+*/
+type scriptedMetric struct {
+	values []float64
+	calls  int
+}
+
+func (m *scriptedMetric) Name() string {
+	return "scripted"
+}
+
+func (m *scriptedMetric) Compute(outputData, targetData [][]float64) float64 {
+	value := m.values[min(m.calls, len(m.values)-1)]
+	m.calls++
+	return value
+}
+
+/*
+epochRecorder copies the parameters at the end of every epoch and cancels
+Cancel, if set, at the first batch of epoch CancelEpoch.
+*/
+type epochRecorder struct {
+	Parameters  *VariableNode
+	Epochs      [][]float64
+	Cancel      context.CancelFunc
+	CancelEpoch int
+}
+
+func (r *epochRecorder) OnBatchEnd(epoch, batch int, loss float64) {
+	if r.Cancel != nil && epoch == r.CancelEpoch && batch == 0 {
+		r.Cancel()
+	}
+}
+
+func (r *epochRecorder) OnEpochEnd(epoch int, logs map[string]float64) {
+	r.Epochs = append(r.Epochs, slices.Clone(r.Parameters.Value.Data))
+}
+
+func trainerData() (inputs, targets [][]float64) {
+	inputs = [][]float64{{1, 2}, {3, 1}, {-1, 2}, {0, 1}}
+	targets = [][]float64{{1}, {2}, {0}, {-1}}
+	return
+}
+
+func TestFitInvalidArguments(t *testing.T) {
+	inputs, targets := trainerData()
+	nn, _, _ := newCheckpointNetwork()
+	for _, c := range []struct {
+		name             string
+		batchSize        int
+		inputs, targets  [][]float64
+		validationInputs [][]float64
+	}{
+		{"zero batch size", 0, inputs, targets, nil},
+		{"negative batch size", -1, inputs, targets, nil},
+		{"no training data", 2, nil, nil, nil},
+		{"more inputs than targets", 2, inputs, targets[:3], nil},
+		{"more validation inputs than targets", 2, inputs, targets, inputs},
+	} {
+		trainer := NewTrainer(nn, 1, c.batchSize)
+		trainer.ValidationInputs = c.validationInputs
+		trainer.ValidationTargets = targets[:1]
+		if _, err := trainer.Fit(context.Background(), c.inputs, c.targets); err == nil {
+			t.Errorf("%s: Fit did not return an error", c.name)
+		}
+	}
+}
+
+func TestFitEarlyStopping(t *testing.T) {
+	inputs, targets := trainerData()
+	nn, _, _ := newCheckpointNetwork()
+	trainer := NewTrainer(nn, 10, 2)
+	trainer.ValidationInputs, trainer.ValidationTargets = inputs, targets
+	trainer.Metrics = []Metric{&scriptedMetric{values: []float64{0.5, 0.7, 0.6, 0.65, 0.9}}}
+	trainer.EarlyStopping = NewEarlyStopping("val_scripted", true, 2, 0)
+	history, err := trainer.Fit(context.Background(), inputs, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The metric improves in epochs 0 and 1 and then not for two epochs.
+	if len(history) != 4 {
+		t.Fatalf("trained %d epochs, want 4", len(history))
+	}
+	if history[3]["val_scripted"] != 0.65 {
+		t.Fatalf("logs of the last epoch %v, want val_scripted 0.65", history[3])
+	}
+
+	trainer.EarlyStopping = NewEarlyStopping("val_missing", true, 2, 0)
+	if _, err := trainer.Fit(context.Background(), inputs, targets); err == nil {
+		t.Fatal("monitoring a value that is not logged did not return an error")
+	}
+}
+
+func TestFitCheckpointBestModel(t *testing.T) {
+	inputs, targets := trainerData()
+	path := filepath.Join(t.TempDir(), "best.json")
+	nn, model, w := newCheckpointNetwork()
+	recorder := &epochRecorder{Parameters: w}
+	trainer := NewTrainer(nn, 4, 2)
+	trainer.ValidationInputs, trainer.ValidationTargets = inputs, targets
+	trainer.Metrics = []Metric{&scriptedMetric{values: []float64{0.3, 0.2, 0.25, 0.4}}}
+	trainer.Callbacks = []Callback{recorder}
+	trainer.Checkpoint = NewModelCheckpoint(model, path, "val_scripted", false)
+	if _, err := trainer.Fit(context.Background(), inputs, targets); err != nil {
+		t.Fatal(err)
+	}
+
+	_, best, bestW := newCheckpointNetwork()
+	if err := best.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(bestW.Value.Data, recorder.Epochs[1]) {
+		t.Fatalf("saved parameters %v, want those of epoch 1 %v", bestW.Value.Data, recorder.Epochs[1])
+	}
+	if slices.Equal(w.Value.Data, recorder.Epochs[1]) {
+		t.Fatalf("training did not change the parameters after epoch 1")
+	}
+}
+
+func TestFitContextCancelled(t *testing.T) {
+	inputs, targets := trainerData()
+	nn, _, w := newCheckpointNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &epochRecorder{Parameters: w, Cancel: cancel, CancelEpoch: 2}
+	trainer := NewTrainer(nn, 10, 1)
+	trainer.Callbacks = []Callback{recorder}
+	history, err := trainer.Fit(ctx, inputs, targets)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("returned the logs of %d epochs, want the 2 completed ones", len(history))
+	}
+	if nn.Epoch() != 2 {
+		t.Fatalf("network is at epoch %d, want 2", nn.Epoch())
+	}
+}